@@ -0,0 +1,123 @@
+// Package address splits the single line addresses of the dataset,
+// e.g. "gorod Moskva, ulitsa Talalihina, dom 2/1, korpus 1",
+// into their structured parts.
+package address
+
+import "strings"
+
+// Address is the structured form of an address line
+type Address struct {
+	City       string `json:"city,omitempty"`
+	Settlement string `json:"settlement,omitempty"`
+	Locality   string `json:"locality,omitempty"`
+	StreetType string `json:"street_type,omitempty"`
+	Street     string `json:"street,omitempty"`
+	House      string `json:"house,omitempty"`
+	Building   string `json:"building,omitempty"`
+	Structure  string `json:"structure,omitempty"`
+}
+
+// Words that name the type of a street, placed before or after the name
+var streetTypes = map[string]bool{
+	"ulitsa":       true,
+	"prospekt":     true,
+	"pereulok":     true,
+	"proezd":       true,
+	"shosse":       true,
+	"bul'var":      true,
+	"ploschad'":    true,
+	"naberezhnaja": true,
+	"alleja":       true,
+	"tupik":        true,
+	"linija":       true,
+	"prosek":       true,
+	"spusk":        true,
+	"val":          true,
+	"kilometr":     true,
+}
+
+// Localities inside a settlement
+var localityTypes = map[string]bool{
+	"poselok":    true,
+	"selo":       true,
+	"derevnja":   true,
+	"mikrorajon": true,
+	"kvartal":    true,
+	"gorod":      true,
+}
+
+// Parse splits a comma separated address line into its parts.
+// Parts it can not classify are treated as the street name.
+func Parse(line string) Address {
+	var a Address
+	for _, part := range strings.Split(line, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, value, _ := strings.Cut(part, " ")
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(kind) {
+		case "gorod":
+			// A second "gorod" is a town inside a settlement
+			if a.City == "" {
+				a.City = value
+			} else {
+				a.Locality = part
+			}
+			continue
+		case "poselenie":
+			a.Settlement = value
+			continue
+		case "dom", "vladenie", "domovladenie":
+			if a.House == "" {
+				a.House = value
+			}
+			continue
+		case "korpus":
+			a.Building = value
+			continue
+		case "stroenie", "sooruzhenie":
+			a.Structure = value
+			continue
+		}
+
+		if localityTypes[strings.ToLower(kind)] && a.Street == "" {
+			a.Locality = part
+			continue
+		}
+		if a.Street == "" {
+			a.StreetType, a.Street = splitStreet(part)
+		}
+	}
+	return a
+}
+
+// Separate the street type word from the street name
+func splitStreet(part string) (string, string) {
+	words := strings.Fields(part)
+	if len(words) < 2 {
+		return "", part
+	}
+	first, last := words[0], words[len(words)-1]
+	if streetTypes[strings.ToLower(first)] {
+		return strings.ToLower(first), strings.Join(words[1:], " ")
+	}
+	if streetTypes[strings.ToLower(last)] {
+		return strings.ToLower(last), strings.Join(words[:len(words)-1], " ")
+	}
+	return "", part
+}
+
+// Area returns the most specific part of the address above the street:
+// the locality, else the settlement, else the city
+func (a Address) Area() string {
+	switch {
+	case a.Locality != "":
+		return a.Locality
+	case a.Settlement != "":
+		return "poselenie " + a.Settlement
+	}
+	return a.City
+}
@@ -0,0 +1,156 @@
+// Package category infers the kind of a venue ("cafe", "bar", ...) from
+// words of its name, using rules kept in an editable text file.
+package category
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"day03es/translit"
+)
+
+// A pattern is a sequence of folded words, the last one may be a prefix
+type pattern struct {
+	words  []string
+	prefix bool
+}
+
+type rule struct {
+	category string
+	patterns []pattern
+}
+
+// Classifier assigns categories to place names
+type Classifier struct {
+	rules   []rule
+	version string
+}
+
+// Load reads the rules from a file
+func Load(path string) (*Classifier, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Parse reads rules of the form "category: pattern, pattern, ...".
+// Empty lines and lines starting with # are ignored.
+func Parse(r io.Reader) (*Classifier, error) {
+	c := &Classifier{}
+	index := make(map[string]int)
+	h := sha256.New()
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, list, ok := strings.Cut(text, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("line %d: expected \"category: pattern, ...\"", line)
+		}
+
+		// A category may be split over several lines
+		i, ok := index[name]
+		if !ok {
+			i = len(c.rules)
+			index[name] = i
+			c.rules = append(c.rules, rule{category: name})
+		}
+		for _, item := range strings.Split(list, ",") {
+			p, ok := parsePattern(item)
+			if !ok {
+				continue
+			}
+			c.rules[i].patterns = append(c.rules[i].patterns, p)
+		}
+		h.Write([]byte(text))
+		h.Write([]byte{'\n'})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	c.version = hex.EncodeToString(h.Sum(nil))[:16]
+	return c, nil
+}
+
+// Fold a pattern the same way names are folded
+func parsePattern(s string) (pattern, bool) {
+	s = strings.TrimSpace(s)
+	p := pattern{prefix: strings.HasSuffix(s, "*")}
+	p.words = strings.Fields(translit.Fold(strings.TrimSuffix(s, "*")))
+	return p, len(p.words) > 0
+}
+
+// Classify returns the categories of a name in the order of the rules,
+// an empty list if no rule matches
+func (c *Classifier) Classify(name string) []string {
+	categories := make([]string, 0)
+	if c == nil {
+		return categories
+	}
+	words := strings.Fields(translit.Fold(name))
+	for _, r := range c.rules {
+		for _, p := range r.patterns {
+			if p.match(words) {
+				categories = append(categories, r.category)
+				break
+			}
+		}
+	}
+	return categories
+}
+
+// Categories returns every category known to the rules
+func (c *Classifier) Categories() []string {
+	if c == nil {
+		return nil
+	}
+	names := make([]string, len(c.rules))
+	for i, r := range c.rules {
+		names[i] = r.category
+	}
+	return names
+}
+
+// Version identifies the rules, it changes whenever a rule is edited
+func (c *Classifier) Version() string {
+	if c == nil {
+		return ""
+	}
+	return c.version
+}
+
+// Check if the words contain the pattern
+func (p pattern) match(words []string) bool {
+	n := len(p.words)
+	for start := 0; start+n <= len(words); start++ {
+		ok := true
+		for i, w := range p.words {
+			word := words[start+i]
+			if i == n-1 && p.prefix {
+				ok = strings.HasPrefix(word, w)
+			} else {
+				ok = word == w
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
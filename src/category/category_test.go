@@ -0,0 +1,40 @@
+package category
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDatasetRules(t *testing.T) {
+	c, err := Load("../../dataset/categories.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Names from the dataset
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Vinnyj bar 13", []string{"bar"}},
+		{"Kafe «VINOTEKA-PROSTYE VESchI»", []string{"cafe", "bar"}},
+		{"Winil Wine Bar", []string{"bar"}},
+		{"Restoran «Hleb i Vino»", []string{"restaurant", "bar", "bakery"}},
+		{"Kofe na vynos PUBLIC CAFE", []string{"cafe", "coffee"}},
+		{"Restoran «Vintazh»", []string{"restaurant"}},
+		{"Hinkal'naja Vinograd", []string{"georgian"}},
+		{"Pitstsa-market «Smajl pitstsa &rolly»", []string{"pizza", "sushi"}},
+		{"Rok-n-roll, Martinez Bar", []string{"bar"}},
+		{"Rolls Race", []string{}},
+		{"Paradiz Rolan", []string{}},
+		{"Кофейня Шоколадница", []string{"coffee"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Classify(tt.name); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,18 +1,52 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"day03es/category"
 	"day03es/db"
+	"day03es/dedup"
+	"day03es/importer"
+	"day03es/types"
 	"day03es/web"
 )
 
+// Default location of the dataset
+const dataPath = "../../dataset/data.csv"
+
+// Default location of the category rules
+const categoriesPath = "../../dataset/categories.txt"
+
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "dedup":
+			os.Exit(runDedup(os.Args[2:]))
+		case "token":
+			os.Exit(runToken(os.Args[2:]))
+		}
+	}
 
 	fSetup := flag.Bool("s", false, "Add data into the database")
 	fAuth := flag.Bool("a", false, "Use authorization to get recommendations")
+	fCategories := flag.String("categories", categoriesPath, "Category rules used with -s")
+	fCheckinDistance := flag.Float64("checkin-distance", web.CheckinDistance, "Farthest distance in meters from a place to check in, 0 to check in from anywhere")
+	fCheckinInterval := flag.Duration("checkin-interval", web.CheckinInterval, "Shortest time between two check-ins of a user at a place")
+	fSecret := flag.String("jwt-secret", "", "Key that signs the tokens (default: $JWT_SECRET), the admin API is off without one")
 	flag.Parse()
+	web.SecretKey = secretKey(*fSecret)
+	web.CheckinDistance = *fCheckinDistance
+	web.CheckinInterval = *fCheckinInterval
 
 	// Set up store
 	store := db.NewElasticStore()
@@ -20,7 +54,12 @@ func main() {
 	if *fSetup {
 		store.CreateIndex("places")
 		store.ApplyMapping()
-		store.AddData("../../dataset/data.csv")
+		categories, err := category.Load(*fCategories)
+		if err != nil {
+			fmt.Printf("Failed to read the category rules: %s\n", err)
+			os.Exit(1)
+		}
+		store.AddData(dataPath, categories)
 	}
 
 	// Create server on port 8888
@@ -29,3 +68,190 @@ func main() {
 		fmt.Printf("Failed to start the server: %s\n", err)
 	}
 }
+
+// Key that signs the tokens, from the flag or the environment
+func secretKey(flagValue string) []byte {
+	if flagValue != "" {
+		return []byte(flagValue)
+	}
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+// Validate and import the dataset, returns the exit code
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fFile := fs.String("file", dataPath, "Path to the dataset")
+	fFormat := fs.String("format", "", "Source format: tsv, csv, geojson, ndjson, osm or pbf (default: from the file extension)")
+	fColumns := fs.String("columns", "", "Column names for tsv/csv, e.g. \"name=Title,lat=Y,lon=X\"")
+	fDryRun := fs.Bool("dry-run", false, "Only validate the rows, do not write anything")
+	fSync := fs.Bool("sync", false, "Only send new, changed and deleted places instead of re-indexing everything")
+	fReport := fs.String("report", "", "Write the validation report to this file (.json or .csv)")
+	fPolicy := fs.String("policy", string(db.PolicySkip), "What to do with invalid rows: skip or abort")
+	fCategories := fs.String("categories", categoriesPath, "Rules that assign categories from the place names")
+	fs.Parse(args)
+
+	policy := db.ImportPolicy(*fPolicy)
+	if policy != db.PolicySkip && policy != db.PolicyAbort {
+		fmt.Printf("Unknown policy '%s'\n", *fPolicy)
+		return 2
+	}
+
+	// Check the report format before doing any work
+	format := strings.TrimPrefix(filepath.Ext(*fReport), ".")
+	if *fReport != "" && format != "json" && format != "csv" {
+		fmt.Printf("Report must be a .json or .csv file\n")
+		return 2
+	}
+
+	columns, err := importer.ParseColumns(*fColumns)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	imp, err := importer.ForFile(*fFile, *fFormat, columns)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	categories, err := category.Load(*fCategories)
+	if err != nil {
+		fmt.Printf("Failed to read the category rules: %s\n", err)
+		return 2
+	}
+
+	store := db.NewElasticStore()
+	if !*fDryRun {
+		store.CreateIndex("places")
+		store.ApplyMapping()
+	}
+
+	opts := db.ImportOptions{DryRun: *fDryRun, Sync: *fSync, Policy: policy, Importer: imp, Categories: categories}
+	report, err := store.Import(*fFile, opts)
+	fmt.Printf("Rows: %d, valid: %d, rejected: %d, warnings: %d, indexed: %d, failed: %d\n",
+		report.Total, report.Valid, len(report.Rejects), len(report.Warnings), report.Indexed, report.Failed)
+	if report.Diff != nil {
+		printDiff(report.Diff)
+	}
+
+	if *fReport != "" {
+		if err := writeReport(report, *fReport, format); err != nil {
+			fmt.Printf("Failed to write the report: %s\n", err)
+			return 1
+		}
+		fmt.Printf("Report written to %s\n", *fReport)
+	}
+
+	if err != nil {
+		fmt.Printf("Import failed: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// Number of ids listed per change type in the sync summary
+const diffListMax = 10
+
+// Print a summary of the changes found by a sync
+func printDiff(diff *db.SyncDiff) {
+	fmt.Printf("Sync: %d new, %d changed, %d deleted, %d unchanged\n",
+		len(diff.New), len(diff.Changed), len(diff.Deleted), diff.Unchanged)
+	for _, group := range []struct {
+		sign string
+		ids  []string
+	}{{"+", diff.New}, {"~", diff.Changed}, {"-", diff.Deleted}} {
+		for i, id := range group.ids {
+			if i == diffListMax {
+				fmt.Printf("%s ... and %d more\n", group.sign, len(group.ids)-diffListMax)
+				break
+			}
+			fmt.Printf("%s %s\n", group.sign, id)
+		}
+	}
+}
+
+func writeReport(report *db.ImportReport, path, format string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return report.WriteReport(file, format)
+}
+
+// Find probable duplicate places and write them as a JSON report,
+// returns the exit code
+func runDedup(args []string) int {
+	fs := flag.NewFlagSet("dedup", flag.ExitOnError)
+	fDistance := fs.Float64("distance", dedup.DefaultOptions.MaxDistance, "Maximum distance between duplicates in meters")
+	fSimilarity := fs.Float64("similarity", dedup.DefaultOptions.MinSimilarity, "Minimum name similarity from 0 to 1")
+	fReport := fs.String("report", "duplicates.json", "Write the groups of duplicates to this file")
+	fs.Parse(args)
+
+	store := db.NewElasticStore()
+	candidates := make([]dedup.Candidate, 0)
+	err := store.ScanPlaces(context.Background(), func(place db.Place) error {
+		var loc types.Location
+		var err error
+		if loc.Lat, err = strconv.ParseFloat(place.Source.Location.Lat, 64); err != nil {
+			return nil
+		}
+		if loc.Lon, err = strconv.ParseFloat(place.Source.Location.Lon, 64); err != nil {
+			return nil
+		}
+		candidates = append(candidates, dedup.Candidate{
+			ID:       place.ID,
+			Name:     place.Source.Name,
+			Phones:   place.Source.Phones,
+			Location: loc,
+		})
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Failed to read the places: %s\n", err)
+		return 1
+	}
+
+	opts := dedup.Options{MaxDistance: *fDistance, MinSimilarity: *fSimilarity}
+	groups := dedup.Find(candidates, opts)
+	duplicates := 0
+	for _, g := range groups {
+		duplicates += len(g.IDs) - 1
+	}
+	fmt.Printf("Places: %d, groups: %d, duplicates: %d\n", len(candidates), len(groups), duplicates)
+
+	file, err := os.Create(*fReport)
+	if err != nil {
+		fmt.Printf("Failed to write the report: %s\n", err)
+		return 1
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(map[string]interface{}{"options": opts, "groups": groups}); err != nil {
+		fmt.Printf("Failed to write the report: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Report written to %s\n", *fReport)
+	return 0
+}
+
+// Print a token for a user, returns the exit code
+func runToken(args []string) int {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	fName := fs.String("name", "admin", "Name stored in the token, reviews are written under it")
+	fAdmin := fs.Bool("admin", false, "Give access to the admin API")
+	fSecret := fs.String("jwt-secret", "", "Key that signs the token, the one the server uses (default: $JWT_SECRET)")
+	fs.Parse(args)
+	web.SecretKey = secretKey(*fSecret)
+
+	token, err := web.UserToken(*fName)
+	if *fAdmin {
+		token, err = web.AdminToken(*fName)
+	}
+	if err != nil {
+		fmt.Printf("Failed to create the token: %s\n", err)
+		return 1
+	}
+	fmt.Println(token)
+	return 0
+}
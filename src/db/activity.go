@@ -0,0 +1,132 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Index of small counters kept apart from the places, so that changing
+// them does not touch the cluster state
+const metaIndex = "place_meta"
+
+// Document counting the changes of ratings and visits, its version is the
+// activity generation
+const activityDoc = "activity"
+
+// Mapping of the meta index
+const metaMapping = `
+{
+  "mappings": {
+    "properties": {
+      "count": { "type": "long" }
+    }
+  }
+}
+`
+
+// The meta index is created on the first review or check-in
+var metaIndexReady = &lazyIndex{name: metaIndex, mapping: metaMapping}
+
+// Script counting one more change
+const countScript = "ctx._source.count = (ctx._source.count == null ? 0 : ctx._source.count) + 1"
+
+// Cached activity generation, apart from the index generation so that
+// reading one does not wait for the other
+var (
+	actMu      sync.Mutex
+	activity   int64
+	actFetched time.Time
+)
+
+// SetActivity counts a change of the ratings or visits. Only the responses
+// with ratings or visits depend on it, tiles and clusters stay cached.
+func (s *ElasticStore) SetActivity() error {
+	if err := s.ensureIndex(metaIndexReady); err != nil {
+		return err
+	}
+	update := map[string]interface{}{
+		"script": map[string]interface{}{"source": countScript},
+		"upsert": map[string]interface{}{"count": 1},
+	}
+	body, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Update(metaIndex, activityDoc, bytes.NewReader(body),
+		s.client.Update.WithContext(context.Background()),
+		s.client.Update.WithRetryOnConflict(10),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("SetActivity: %s", res.String())
+	}
+
+	var result struct {
+		Version int64 `json:"_version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+	storeActivity(result.Version)
+	return nil
+}
+
+// ActivityGeneration returns an identifier that changes with the ratings
+// and visits of the places
+func (s *ElasticStore) ActivityGeneration() (string, error) {
+	actMu.Lock()
+	fresh := !actFetched.IsZero() && time.Since(actFetched) <= generationTTL
+	version := activity
+	actMu.Unlock()
+
+	if !fresh {
+		var err error
+		if version, err = s.fetchActivity(); err != nil {
+			return "", err
+		}
+		storeActivity(version)
+	}
+	return strconv.FormatInt(version, 10), nil
+}
+
+// Remember the activity generation, an older one read concurrently does
+// not replace a newer one
+func storeActivity(version int64) {
+	actMu.Lock()
+	defer actMu.Unlock()
+	if version > activity {
+		activity = version
+	}
+	actFetched = time.Now()
+}
+
+// Read the version of the activity counter, 0 if nothing changed yet
+func (s *ElasticStore) fetchActivity() (int64, error) {
+	res, err := s.client.Get(metaIndex, activityDoc, s.client.Get.WithContext(context.Background()))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return 0, nil
+	} else if res.IsError() {
+		return 0, fmt.Errorf("fetchActivity: %s", res.String())
+	}
+
+	var doc struct {
+		Version int64 `json:"_version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return 0, err
+	}
+	return doc.Version, nil
+}
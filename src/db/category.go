@@ -0,0 +1,72 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"day03es/types"
+)
+
+// Most categories returned by CategoryCounts
+const maxCategories = 100
+
+// CategoryCounts returns the number of places matching the filter in
+// each category. A place with several categories counts in each of them.
+func (s *ElasticStore) CategoryCounts(filter types.Filter) (map[string]int, error) {
+	query := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": filterClauses(filter),
+			},
+		},
+		"aggs": map[string]interface{}{
+			"categories": map[string]interface{}{
+				"terms": map[string]interface{}{
+					"field": "category",
+					"size":  maxCategories,
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex("places"),
+		s.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("CategoryCounts: %s", res.String())
+	}
+
+	var result struct {
+		Aggregations struct {
+			Categories struct {
+				Buckets []struct {
+					Key      string `json:"key"`
+					DocCount int    `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"categories"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(result.Aggregations.Categories.Buckets))
+	for _, b := range result.Aggregations.Categories.Buckets {
+		counts[b.Key] = b.DocCount
+	}
+	return counts, nil
+}
@@ -0,0 +1,340 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"day03es/types"
+)
+
+// Index of the check-ins, one document per visit
+const checkinsIndex = "place_checkins"
+
+// Places whose visits are counted per request of the visits aggregation
+const visitsPageSize = 1000
+
+// Mapping of the check-ins index
+const checkinsMapping = `
+{
+  "mappings": {
+    "properties": {
+      "place_id":   { "type": "keyword" },
+      "user":       { "type": "keyword" },
+      "at":         { "type": "date" },
+      "location":   { "type": "geo_point" },
+      "distance_m": { "type": "float" }
+    }
+  }
+}
+`
+
+// The check-ins index is created on the first check-in
+var checkinsIndexReady = &lazyIndex{name: checkinsIndex, mapping: checkinsMapping}
+
+// Script counting a visit on a place document
+const addVisitScript = "ctx._source.visits = (ctx._source.visits == null ? 0 : ctx._source.visits) + 1"
+
+// AddCheckin records a visit of a user to a place, counts it on the place
+// and in the preferences of the user. A check-in with a location farther
+// than maxDistance meters from the place is rejected with types.ErrTooFar,
+// no distance is checked if maxDistance is 0. The time is split into
+// periods of interval, a second check-in of the user at the place in the
+// same period is rejected with types.ErrTooSoon.
+func (s *ElasticStore) AddCheckin(checkin types.Checkin, maxDistance float64, interval time.Duration) (*types.Checkin, error) {
+	place, err := s.GetPlace(checkin.PlaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if checkin.Location != nil && maxDistance > 0 {
+		lat, err := strconv.ParseFloat(place.Source.Location.Lat, 64)
+		if err != nil {
+			return nil, err
+		}
+		lon, err := strconv.ParseFloat(place.Source.Location.Lon, 64)
+		if err != nil {
+			return nil, err
+		}
+		d := types.Distance(
+			types.Location{Lat: checkin.Location.Lat, Lon: checkin.Location.Lon},
+			types.Location{Lat: lat, Lon: lon},
+		)
+		if d > maxDistance {
+			return nil, fmt.Errorf("%w: %.0f m", types.ErrTooFar, d)
+		}
+		d = round3(d)
+		checkin.DistanceM = &d
+	}
+
+	if err := s.ensureIndex(checkinsIndexReady); err != nil {
+		return nil, err
+	}
+	checkin.At = time.Now().UTC().Truncate(time.Second)
+	if interval > 0 {
+		// One check-in per user, place and interval: a second one, even
+		// sent at the same time, finds the id taken
+		bucket := checkin.At.UnixNano() / int64(interval)
+		checkin.ID = fmt.Sprintf("%s:%s:%d", checkin.PlaceID, checkin.User, bucket)
+		err = s.createDocument(checkinsIndex, checkin.ID, checkin)
+		if err == errConflict {
+			next := time.Unix(0, (bucket+1)*int64(interval)).UTC()
+			return nil, fmt.Errorf("%w, try again after %s", types.ErrTooSoon, next.Format(time.RFC3339))
+		}
+	} else {
+		if checkin.ID, err = randomID(8); err != nil {
+			return nil, err
+		}
+		err = s.putDocument(checkinsIndex, checkin.ID, checkin)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	update := map[string]interface{}{"script": map[string]interface{}{"source": addVisitScript}}
+	if err := s.updateDocument("places", checkin.PlaceID, update); err != nil && err != types.ErrNotFound {
+		return nil, err
+	}
+	updateCachedPlace(checkin.PlaceID, func(source *Source) { source.Visits++ })
+	if err := s.learn(checkin.User, place.Source.Category, checkinWeight); err != nil {
+		return nil, err
+	}
+
+	// Cached responses show the old number of visits
+	if err := s.SetActivity(); err != nil {
+		return nil, err
+	}
+	return &checkin, nil
+}
+
+// ListCheckins returns a page of the check-ins of a user, newest first,
+// and their total
+func (s *ElasticStore) ListCheckins(user string, limit, offset int) ([]types.Checkin, int, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, 0, types.ErrInvalidPage
+	}
+
+	query := map[string]interface{}{
+		"from": offset,
+		"size": limit,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"user": user}},
+				},
+			},
+		},
+		"sort": []interface{}{map[string]interface{}{"at": "desc"}},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, 0, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex(checkinsIndex),
+		s.client.Search.WithBody(&buf),
+		s.client.Search.WithTrackTotalHits(true),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	// Nobody checked in yet
+	if res.StatusCode == http.StatusNotFound {
+		return []types.Checkin{}, 0, nil
+	} else if res.IsError() {
+		return nil, 0, fmt.Errorf("ListCheckins: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Source types.Checkin `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+	checkins := make([]types.Checkin, len(result.Hits.Hits))
+	for i, hit := range result.Hits.Hits {
+		checkins[i] = hit.Source
+	}
+	return checkins, result.Hits.Total.Value, nil
+}
+
+// Count the check-ins of every place, paging through a composite
+// aggregation so that no visited place is left out
+func (s *ElasticStore) fetchVisits() (map[string]int, error) {
+	visits := make(map[string]int)
+	var after interface{}
+	for {
+		composite := map[string]interface{}{
+			"size": visitsPageSize,
+			"sources": []interface{}{
+				map[string]interface{}{"place": map[string]interface{}{"terms": map[string]interface{}{"field": "place_id"}}},
+			},
+		}
+		if after != nil {
+			composite["after"] = after
+		}
+		query := map[string]interface{}{
+			"size": 0,
+			"aggs": map[string]interface{}{
+				"places": map[string]interface{}{"composite": composite},
+			},
+		}
+
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(query); err != nil {
+			return nil, err
+		}
+
+		res, err := s.client.Search(
+			s.client.Search.WithContext(context.Background()),
+			s.client.Search.WithIndex(checkinsIndex),
+			s.client.Search.WithBody(&buf),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Nobody checked in yet
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			return visits, nil
+		} else if res.IsError() {
+			defer res.Body.Close()
+			return nil, fmt.Errorf("fetchVisits: %s", res.String())
+		}
+
+		var result struct {
+			Aggregations struct {
+				Places struct {
+					AfterKey json.RawMessage `json:"after_key"`
+					Buckets  []struct {
+						Key struct {
+							Place string `json:"place"`
+						} `json:"key"`
+						DocCount int `json:"doc_count"`
+					} `json:"buckets"`
+				} `json:"places"`
+			} `json:"aggregations"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		places := result.Aggregations.Places
+		for _, b := range places.Buckets {
+			visits[b.Key.Place] = b.DocCount
+		}
+		if len(places.Buckets) < visitsPageSize || len(places.AfterKey) == 0 {
+			return visits, nil
+		}
+		after = places.AfterKey
+	}
+}
+
+// Give the check-ins of merged duplicates to the canonical place and count
+// its visits again
+func (s *ElasticStore) moveCheckins(canonicalID string, duplicateIDs []string) error {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"terms": map[string]interface{}{"place_id": duplicateIDs},
+		},
+		"script": map[string]interface{}{
+			"source": "ctx._source.place_id = params.id",
+			"params": map[string]interface{}{"id": canonicalID},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return err
+	}
+
+	res, err := s.client.UpdateByQuery([]string{checkinsIndex},
+		s.client.UpdateByQuery.WithContext(context.Background()),
+		s.client.UpdateByQuery.WithBody(&buf),
+		s.client.UpdateByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Nobody checked in yet
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	} else if res.IsError() {
+		return fmt.Errorf("moveCheckins: %s", res.String())
+	}
+
+	var result struct {
+		Updated int `json:"updated"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.Updated == 0 {
+		return nil
+	}
+	return s.updateVisits(canonicalID)
+}
+
+// Store the number of check-ins of a place on its document
+func (s *ElasticStore) updateVisits(placeID string) error {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{"place_id": placeID},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return err
+	}
+
+	res, err := s.client.Count(
+		s.client.Count.WithContext(context.Background()),
+		s.client.Count.WithIndex(checkinsIndex),
+		s.client.Count.WithBody(&buf),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("updateVisits: %s", res.String())
+	}
+
+	var result struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	update := map[string]interface{}{"doc": map[string]interface{}{"visits": result.Count}}
+	if err := s.updateDocument("places", placeID, update); err != nil && err != types.ErrNotFound {
+		return err
+	}
+	updateCachedPlace(placeID, func(source *Source) { source.Visits = result.Count })
+
+	// Cached responses show the old number of visits
+	return s.SetActivity()
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"day03es/tiles"
+	"day03es/types"
+)
+
+// Most tiles a cluster request may aggregate
+const maxClusterCells = 16384
+
+// Clusters groups the places in a box by the map tiles of a zoom level,
+// the precision. Tiles with fewer places than threshold give the places
+// themselves instead of a cluster. Both are sorted by key and id.
+func (s *ElasticStore) Clusters(bbox tiles.BBox, precision int, filter types.Filter, threshold int) ([]types.Cluster, []types.MapPlace, error) {
+	if tiles.Count(bbox, precision) > maxClusterCells {
+		return nil, nil, types.ErrTooManyCells
+	}
+
+	query := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": append(filterClauses(filter), bboxClause(bbox)),
+			},
+		},
+		"aggs": map[string]interface{}{
+			"cells": map[string]interface{}{
+				"geotile_grid": map[string]interface{}{
+					"field":     "location",
+					"precision": precision,
+					"size":      maxClusterCells,
+				},
+				"aggs": map[string]interface{}{
+					"centroid": map[string]interface{}{
+						"geo_centroid": map[string]interface{}{"field": "location"},
+					},
+					"places": map[string]interface{}{
+						"top_hits": map[string]interface{}{
+							"size":    threshold,
+							"_source": []string{"name", "category", "location"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, nil, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex("places"),
+		s.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, nil, fmt.Errorf("Clusters: %s", res.String())
+	}
+
+	var result struct {
+		Aggregations struct {
+			Cells struct {
+				Buckets []struct {
+					Key      string `json:"key"`
+					DocCount int    `json:"doc_count"`
+					Centroid struct {
+						Location types.GeoPoint `json:"location"`
+					} `json:"centroid"`
+					Places struct {
+						Hits struct {
+							Hits []Place `json:"hits"`
+						} `json:"hits"`
+					} `json:"places"`
+				} `json:"buckets"`
+			} `json:"cells"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, nil, err
+	}
+
+	clusters := make([]types.Cluster, 0)
+	places := make([]types.MapPlace, 0)
+	for _, b := range result.Aggregations.Cells.Buckets {
+		if b.DocCount >= threshold {
+			clusters = append(clusters, types.Cluster{
+				Key:   b.Key,
+				Count: b.DocCount,
+				Location: types.GeoPoint{
+					Lat: round6(b.Centroid.Location.Lat),
+					Lon: round6(b.Centroid.Location.Lon),
+				},
+			})
+			continue
+		}
+		for _, hit := range b.Places.Hits.Hits {
+			if place, ok := mapPlace(hit); ok {
+				places = append(places, place)
+			}
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Key < clusters[j].Key })
+	sort.Slice(places, func(i, j int) bool { return types.LessID(places[i].ID, places[j].ID) })
+	return clusters, places, nil
+}
+
+// Filter on the places inside a box
+func bboxClause(b tiles.BBox) map[string]interface{} {
+	return map[string]interface{}{
+		"geo_bounding_box": map[string]interface{}{
+			"location": map[string]interface{}{
+				"top_left":     map[string]float64{"lat": b.MaxLat, "lon": b.MinLon},
+				"bottom_right": map[string]float64{"lat": b.MinLat, "lon": b.MaxLon},
+			},
+		},
+	}
+}
+
+// Marker of a place, false if its location is invalid
+func mapPlace(p Place) (types.MapPlace, bool) {
+	lat, err := strconv.ParseFloat(p.Source.Location.Lat, 64)
+	if err != nil {
+		return types.MapPlace{}, false
+	}
+	lon, err := strconv.ParseFloat(p.Source.Location.Lon, 64)
+	if err != nil {
+		return types.MapPlace{}, false
+	}
+	category := p.Source.Category
+	if category == nil {
+		category = []string{}
+	}
+	return types.MapPlace{
+		ID:       p.ID,
+		Name:     p.Source.Name,
+		Category: category,
+		Location: types.GeoPoint{Lat: lat, Lon: lon},
+	}, true
+}
+
+// Keep six decimals of a coordinate, about 10 cm
+func round6(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"day03es/tiles"
+	"day03es/types"
+)
+
+// Density counts the places in each map tile of a box at a precision, the
+// zoom level of the tiles. Empty tiles are left out, the others are
+// sorted by key.
+func (s *ElasticStore) Density(bbox tiles.BBox, precision int, filter types.Filter, withRating bool) ([]types.DensityCell, error) {
+	if tiles.Count(bbox, precision) > maxClusterCells {
+		return nil, types.ErrTooManyCells
+	}
+
+	cells := map[string]interface{}{
+		"geotile_grid": map[string]interface{}{
+			"field":     "location",
+			"precision": precision,
+			"size":      maxClusterCells,
+		},
+	}
+	if withRating {
+		cells["aggs"] = map[string]interface{}{
+			"rating": map[string]interface{}{"avg": map[string]interface{}{"field": "rating.average"}},
+		}
+	}
+	query := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": append(filterClauses(filter), bboxClause(bbox)),
+			},
+		},
+		"aggs": map[string]interface{}{"cells": cells},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex("places"),
+		s.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("Density: %s", res.String())
+	}
+
+	var result struct {
+		Aggregations struct {
+			Cells struct {
+				Buckets []struct {
+					Key      string `json:"key"`
+					DocCount int    `json:"doc_count"`
+					Rating   struct {
+						// null without rated places
+						Value *float64 `json:"value"`
+					} `json:"rating"`
+				} `json:"buckets"`
+			} `json:"cells"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	density := make([]types.DensityCell, len(result.Aggregations.Cells.Buckets))
+	for i, b := range result.Aggregations.Cells.Buckets {
+		density[i] = types.DensityCell{Key: b.Key, Count: b.DocCount}
+		if b.Rating.Value != nil {
+			rating := roundRating(*b.Rating.Value)
+			density[i].Rating = &rating
+		}
+	}
+	sort.Slice(density, func(i, j int) bool { return density[i].Key < density[j].Key })
+	return density, nil
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"day03es/diversity"
+	"day03es/translit"
+	"day03es/types"
+)
+
+// Pick up to limit varied places out of the ranked candidates
+func diversify(places []types.RecPlace, limit int, opts types.Diversity) []types.RecPlace {
+	items := make([]diversity.Item, len(places))
+	for i, place := range places {
+		items[i] = diversity.Item{
+			Chain:      translit.Fold(place.Name),
+			Categories: place.Categories,
+		}
+	}
+
+	picked := diversity.Select(items, limit, diversity.Options{
+		Lambda:     opts.Lambda,
+		Chains:     opts.Chains,
+		Categories: opts.Categories,
+	})
+	result := make([]types.RecPlace, len(picked))
+	for i, j := range picked {
+		result[i] = places[j]
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"day03es/types"
+)
+
+func TestDiversify(t *testing.T) {
+	// The chain comes from the folded name, whatever its spelling
+	places := []types.RecPlace{
+		{PlaceID: "1", Name: "Шоколадница"},
+		{PlaceID: "2", Name: "SHOKOLADNITSA"},
+		{PlaceID: "3", Name: "Shokoladnica!"},
+		{PlaceID: "4", Name: "Coffee House"},
+		{PlaceID: "5", Name: "Cofix"},
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		opts  types.Diversity
+		want  []string
+	}{
+		{"spellings of one chain", 5, types.Diversity{Lambda: 1, Chains: true}, []string{"1", "4", "5"}},
+		{"trimmed to the limit", 2, types.Diversity{Lambda: 1}, []string{"1", "2"}},
+		{"limit above the candidates", 10, types.Diversity{Lambda: 1}, []string{"1", "2", "3", "4", "5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, p := range diversify(places, tt.limit, tt.opts) {
+				got = append(got, p.PlaceID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,15 +1,12 @@
 package db
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -17,16 +14,48 @@ import (
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
-	"github.com/elastic/go-elasticsearch/v8/esutil"
 
+	"day03es/category"
+	"day03es/hours"
 	"day03es/types"
 )
 
-// Limit of recommended places
+// Default number of recommended places
 const recLimit = 3
 
-// Collection of search results
-var AllPlaces []Place
+// Candidates fetched per recommended place when diversifying
+const recCandidates = 5
+
+// Collection of search results, shared by concurrent requests and
+// dropped by Generation when the index is rebuilt
+var allPlaces atomic.Pointer[[]Place]
+
+// Change one cached place, e.g. its rating or visits. The list is copied,
+// so requests paging through the old one keep a consistent snapshot.
+func updateCachedPlace(id string, fn func(*Source)) {
+	for {
+		cached := allPlaces.Load()
+		if cached == nil {
+			return
+		}
+		i := -1
+		for j, place := range *cached {
+			if place.ID == id {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return
+		}
+		all := make([]Place, len(*cached))
+		copy(all, *cached)
+		fn(&all[i].Source)
+		if allPlaces.CompareAndSwap(cached, &all) {
+			return
+		}
+	}
+}
 
 // ElasticStore implements the Store interface using Elasticsearch.
 type ElasticStore struct {
@@ -85,16 +114,92 @@ func (s *ElasticStore) ApplyMapping() {
 	{
 	  "properties": {
 	    "name": {
-	        "type":  "text"
+	        "type":  "text",
+	        "fields": {
+	          "suggest": {
+	            "type": "search_as_you_type"
+	          }
+	        }
 	    },
 	    "address": {
-	        "type":  "text"
+	        "type":  "text",
+	        "fields": {
+	          "suggest": {
+	            "type": "search_as_you_type"
+	          }
+	        }
+	    },
+	    "address_parsed": {
+	        "properties": {
+	          "city":        { "type": "text", "fields": { "keyword": { "type": "keyword" } } },
+	          "settlement":  { "type": "text", "fields": { "keyword": { "type": "keyword" } } },
+	          "locality":    { "type": "text", "fields": { "keyword": { "type": "keyword" } } },
+	          "street_type": { "type": "keyword" },
+	          "street":      { "type": "text", "fields": { "keyword": { "type": "keyword" } } },
+	          "house":       { "type": "keyword" },
+	          "building":    { "type": "keyword" },
+	          "structure":   { "type": "keyword" }
+	        }
+	    },
+	    "name_folded": {
+	        "type":  "search_as_you_type"
+	    },
+	    "address_folded": {
+	        "type":  "search_as_you_type"
 	    },
 	    "phone": {
 	        "type":  "text"
 	    },
+	    "phones": {
+	        "type":  "keyword"
+	    },
+	    "chain": {
+	        "type":  "keyword"
+	    },
+	    "category": {
+	        "type":  "keyword"
+	    },
+	    "area": {
+	        "type":  "keyword"
+	    },
+	    "opening_hours": {
+	        "type":  "keyword",
+	        "index": false
+	    },
+	    "open_minutes": {
+	        "type":  "integer_range"
+	    },
+	    "rating": {
+	        "properties": {
+	          "average": { "type": "float" },
+	          "count":   { "type": "integer" }
+	        }
+	    },
+	    "visits": {
+	        "type":  "integer"
+	    },
 	    "location": {
 	      "type": "geo_point"
+	    },
+	    "aliases": {
+	        "type":  "text"
+	    },
+	    "merged_ids": {
+	        "type":  "keyword"
+	    },
+	    "content_hash": {
+	        "type":  "keyword"
+	    },
+	    "updated_at": {
+	        "type":  "date"
+	    },
+	    "source": {
+	        "properties": {
+	          "format":      { "type": "keyword" },
+	          "file":        { "type": "keyword" },
+	          "ref":         { "type": "keyword" },
+	          "imported_at": { "type": "date" }
+	        }
 	    }
 	  }
 	}
@@ -121,96 +226,18 @@ func (s *ElasticStore) ApplyMapping() {
 	fmt.Println("Mapping applied successfully.")
 }
 
-// AddData adds data to the index.
-func (s *ElasticStore) AddData(path string) uint64 {
-	var countSuccessful uint64
-	file, err := os.Open(path)
-	if err != nil {
-		log.Fatalf("Error opening CSV file: %s", err)
-	}
-	defer file.Close()
-
-	// Create a CSV reader
-	reader := csv.NewReader(bufio.NewReader(file))
-	reader.Comma = '\t'
-
-	// Skip the header row
-	_, err = reader.Read()
-	if err != nil {
-		log.Fatalf("Error reading CSV header: %s", err)
-	}
-
-	// Create the BulkIndexer
-	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
-		Index:         "places",         // index name
-		Client:        s.client,         // Elasticsearch client
-		NumWorkers:    2,                // The number of worker goroutines
-		FlushBytes:    1024 * 1024,      // The flush threshold in bytes
-		FlushInterval: 30 * time.Second, // The periodic flush interval
-	})
+// AddData adds data to the index, skipping invalid rows.
+func (s *ElasticStore) AddData(path string, categories *category.Classifier) uint64 {
+	report, err := s.Import(path, ImportOptions{Policy: PolicySkip, Categories: categories})
 	if err != nil {
-		log.Fatalf("Error creating the indexer: %s", err)
-	}
-
-	// Read and parse the CSV file
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			break // End of file
-		}
-
-		// Construct a JSON object from the CSV record
-		data := map[string]interface{}{
-			"name":     record[1],
-			"address":  record[2],
-			"phone":    record[3],
-			"location": map[string]interface{}{"lat": record[5], "lon": record[4]},
-		}
-
-		// Encode the JSON object
-		jsonData, err := json.Marshal(data)
-		if err != nil {
-			log.Fatalf("Error encoding JSON: %s", err)
-		}
-
-		// Add an item to the BulkIndexer
-		err = bi.Add(
-			context.Background(),
-			esutil.BulkIndexerItem{
-				Action:     "index",
-				DocumentID: record[0],
-				Body:       bytes.NewReader(jsonData),
-				// OnSuccess is called for each successful operation
-				OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-					atomic.AddUint64(&countSuccessful, 1)
-				},
-				// OnFailure is called for each failed operation
-				OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-					if err != nil {
-						log.Printf("ERROR: %s", err)
-					} else {
-						log.Printf("ERROR: %s: %s", res.Error.Type, res.Error.Reason)
-					}
-				},
-			},
-		)
-		if err != nil {
-			log.Fatalf("Unexpected error: %s", err)
-		}
-	}
-
-	// Close the indexer
-	if err := bi.Close(context.Background()); err != nil {
-		log.Fatalf("Unexpected error: %s", err)
+		log.Printf("Error importing data: %s", err)
 	}
-	biStats := bi.Stats()
-
-	if biStats.NumFailed > 0 {
-		log.Fatalf("Indexed [%d] documents with [%d] errors", biStats.NumFlushed, biStats.NumFailed)
+	if len(report.Rejects) > 0 {
+		log.Printf("Indexed [%d] documents, [%d] rows rejected", report.Indexed, len(report.Rejects))
 	} else {
-		log.Printf("Sucessfuly indexed [%d] documents", biStats.NumFlushed)
+		log.Printf("Sucessfuly indexed [%d] documents", report.Indexed)
 	}
-	return countSuccessful
+	return uint64(report.Indexed)
 }
 
 // Fetch all places from the index
@@ -261,21 +288,32 @@ func (s *ElasticStore) GetAllPlaces() ([]Place, error) {
 }
 
 // Get a page of results
-func (s *ElasticStore) GetPlaces(limit int, offset int) ([]Place, int, error) {
-	if len(AllPlaces) == 0 {
+func (s *ElasticStore) GetPlaces(limit int, offset int, filter types.Filter) ([]Place, int, error) {
+	// Filtered lists are not cached, ES does the paging
+	if !filter.IsEmpty() {
+		return s.filterPlaces(limit, offset, filter)
+	}
+
+	// Take a snapshot, the cache may be dropped when the index is rebuilt
+	var all []Place
+	if cached := allPlaces.Load(); cached != nil {
+		all = *cached
+	}
+	if len(all) == 0 {
 		var err error
-		AllPlaces, err = s.GetAllPlaces()
+		all, err = s.GetAllPlaces()
 		if err != nil {
 			log.Println("GetPlaces:", err)
 			return nil, 0, err
 		}
+		allPlaces.Store(&all)
 	}
 
 	if offset < 0 {
 		return nil, 0, types.ErrInvalidPage
 	}
 
-	ln := len(AllPlaces)
+	ln := len(all)
 	if offset >= ln {
 		return nil, 0, types.ErrInvalidPage
 	}
@@ -285,29 +323,61 @@ func (s *ElasticStore) GetPlaces(limit int, offset int) ([]Place, int, error) {
 	if limit < 0 {
 		return nil, 0, types.ErrInvalidPage
 	}
-	res := AllPlaces[offset : offset+limit]
+	res := all[offset : offset+limit]
 	return res, ln, nil
 }
 
-func (es *ElasticStore) GetRecommended(lat, lon float64) ([]types.RecPlace, error) {
-	// Define the Elasticsearch query for searching three closest restaurants
+func (es *ElasticStore) GetRecommended(lat, lon float64, filter types.Filter, opts types.RecOptions) ([]types.RecPlace, error) {
+	// Opening hours are evaluated at the filtered time, or now
+	at := filter.OpenAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	here := types.Location{Lat: lat, Lon: lon}
+	origin := map[string]interface{}{
+		"lat": lat,
+		"lon": lon,
+	}
+	byDistance := map[string]interface{}{
+		"_geo_distance": map[string]interface{}{
+			"location":        origin,
+			"order":           "asc",
+			"unit":            "km",
+			"mode":            "min",
+			"distance_type":   "arc",
+			"ignore_unmapped": true,
+		},
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = recLimit
+	}
+
+	// Define the Elasticsearch query for searching the closest restaurants
 	query := map[string]interface{}{
-		"size": recLimit,
-		"sort": []map[string]interface{}{
-			{
-				"_geo_distance": map[string]interface{}{
-					"location": map[string]interface{}{
-						"lat": lat,
-						"lon": lon,
-					},
-					"order":           "asc",
-					"unit":            "km",
-					"mode":            "min",
-					"distance_type":   "arc",
-					"ignore_unmapped": true,
-				},
+		"size": limit,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": filterClauses(filter),
 			},
 		},
+		"sort": []interface{}{byDistance},
+	}
+
+	// Diversifying and personalizing pick among more candidates
+	diverse := opts.Diversity.Chains || opts.Diversity.Categories
+	personal := len(opts.Personal) > 0
+	if diverse || personal {
+		query["size"] = limit * recCandidates
+	}
+
+	// Rank by the profile, the nearest places if only distance matters
+	profile := opts.Profile
+	if !distanceOnly(profile, opts.Prefer) {
+		query["query"] = rankQuery(query["query"].(map[string]interface{}), origin, profile, opts.Prefer)
+		query["sort"] = []interface{}{"_score", byDistance}
 	}
 
 	// Encode the query as JSON
@@ -328,47 +398,91 @@ func (es *ElasticStore) GetRecommended(lat, lon float64) ([]types.RecPlace, erro
 	}
 	defer res.Body.Close()
 
+	if res.IsError() {
+		return nil, fmt.Errorf("GetRecommended: %s", res.String())
+	}
+
 	// Parse the response JSON
-	var result map[string]interface{}
+	var result struct {
+		Hits struct {
+			Hits []Place `json:"hits"`
+		} `json:"hits"`
+	}
 	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
 		return nil, err
 	}
 
 	// Extract the recommended places from the response
-	places := make([]types.RecPlace, 0)
-
-	hits, _ := result["hits"].(map[string]interface{})["hits"].([]interface{})
-	for _, hit := range hits {
-		source := hit.(map[string]interface{})["_source"].(map[string]interface{})
-		lat, err := strconv.ParseFloat(source["location"].(map[string]interface{})["lat"].(string), 64)
-		if err != nil {
-			continue
-		}
-
-		lon, err := strconv.ParseFloat(source["location"].(map[string]interface{})["lon"].(string), 64)
+	places := make([]types.RecPlace, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		source := hit.Source
+		lat, err := strconv.ParseFloat(source.Location.Lat, 64)
 		if err != nil {
 			continue
 		}
-		location := types.Location{
-			Lat: lat,
-			Lon: lon,
-		}
-
-		id, err := strconv.Atoi(hit.(map[string]interface{})["_id"].(string))
+		lon, err := strconv.ParseFloat(source.Location.Lon, 64)
 		if err != nil {
 			continue
 		}
 
 		place := types.RecPlace{
-			ID:       id,
-			Name:     source["name"].(string),
-			Address:  source["address"].(string),
-			Phone:    source["phone"].(string),
-			Location: location,
+			OpeningHours: source.OpeningHours,
+			Rating:       source.Rating,
+			ID:           types.NumericID(hit.ID),
+			PlaceID:      hit.ID,
+			Name:         source.Name,
+			Address:      source.Address,
+			Phone:        source.Phone,
+			Phones:       nonNil(source.Phones),
+			Categories:   nonNil(source.Category),
+			Location:     types.Location{Lat: lat, Lon: lon},
+		}
+		// An empty rating means there are no published reviews
+		if place.Rating != nil && place.Rating.Count == 0 {
+			place.Rating = nil
 		}
 
+		setOpenState(&place, at)
+		if opts.Explain {
+			place.Score = explainScore(place, here, profile, opts.Prefer)
+		}
 		places = append(places, place)
 	}
 
+	if personal {
+		places = personalize(places, opts.Personal, opts.Explain)
+	}
+	if diverse {
+		places = diversify(places, limit, opts.Diversity)
+	} else if len(places) > limit {
+		places = places[:limit]
+	}
 	return places, nil
 }
+
+// Empty instead of nil, so that the list is [] in JSON
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// Fill in whether a place is open at a time and when that changes
+func setOpenState(place *types.RecPlace, at time.Time) {
+	if place.OpeningHours == "" {
+		return
+	}
+	schedule, err := hours.Parse(place.OpeningHours)
+	if err != nil {
+		return
+	}
+	open := schedule.OpenAt(at)
+	place.OpenNow = &open
+	if t, ok := schedule.NextOpen(at); ok {
+		place.NextOpen = &t
+	}
+	if t, ok := schedule.NextClose(at); ok {
+		place.NextClose = &t
+	}
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Number of documents fetched per scan request
+const scanBatch = 1000
+
+// How long the point in time is kept open between two scan requests
+const pitKeepAlive = "1m"
+
+// Returned by scanIndex when the index does not exist
+var errNoIndex = errors.New("index not found")
+
+// ScanPlaces walks over every document of the index using a point in time
+// and search_after, calling fn for each place. Only one batch is kept in memory.
+func (s *ElasticStore) ScanPlaces(ctx context.Context, fn func(Place) error) error {
+	return s.scanIndex(ctx, "places", func(hit json.RawMessage) error {
+		var p Place
+		if err := json.Unmarshal(hit, &p); err != nil {
+			return err
+		}
+		return fn(p)
+	})
+}
+
+// Call fn with every hit of an index, in batches read through a point in time
+func (s *ElasticStore) scanIndex(ctx context.Context, index string, fn func(json.RawMessage) error) error {
+	pit, err := s.openPIT(ctx, index)
+	if err != nil {
+		return err
+	}
+	defer s.closePIT(pit)
+
+	// Sort values are passed back as they are, they may not fit a float64
+	var after []json.RawMessage
+	for {
+		query := map[string]interface{}{
+			"size": scanBatch,
+			"pit": map[string]interface{}{
+				"id":         pit,
+				"keep_alive": pitKeepAlive,
+			},
+			"sort":             []interface{}{map[string]string{"_shard_doc": "asc"}},
+			"track_total_hits": false,
+		}
+		if after != nil {
+			query["search_after"] = after
+		}
+
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(query); err != nil {
+			return err
+		}
+
+		// The index is part of the point in time, so it must not be set here
+		res, err := s.client.Search(
+			s.client.Search.WithContext(ctx),
+			s.client.Search.WithBody(&buf),
+		)
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			PitID string `json:"pit_id"`
+			Hits  struct {
+				Hits []json.RawMessage `json:"hits"`
+			} `json:"hits"`
+		}
+		if res.IsError() {
+			res.Body.Close()
+			return fmt.Errorf("scanIndex %s: %s", index, res.String())
+		}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		hits := result.Hits.Hits
+		if len(hits) == 0 {
+			return nil
+		}
+		for _, hit := range hits {
+			if err := fn(hit); err != nil {
+				return err
+			}
+		}
+
+		// ES may hand out a new PIT id on every request
+		if result.PitID != "" {
+			pit = result.PitID
+		}
+		var last struct {
+			Sort []json.RawMessage `json:"sort"`
+		}
+		if err := json.Unmarshal(hits[len(hits)-1], &last); err != nil {
+			return err
+		}
+		after = last.Sort
+	}
+}
+
+// Open a point in time on an index
+func (s *ElasticStore) openPIT(ctx context.Context, index string) (string, error) {
+	res, err := s.client.OpenPointInTime(
+		[]string{index},
+		pitKeepAlive,
+		s.client.OpenPointInTime.WithContext(ctx),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return "", errNoIndex
+	} else if res.IsError() {
+		return "", fmt.Errorf("openPIT: %s", res.String())
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// Release a point in time, errors are not important here
+func (s *ElasticStore) closePIT(pit string) {
+	body := fmt.Sprintf(`{"id": %q}`, pit)
+	res, err := s.client.ClosePointInTime(
+		s.client.ClosePointInTime.WithContext(context.Background()),
+		s.client.ClosePointInTime.WithBody(strings.NewReader(body)),
+	)
+	if err == nil {
+		res.Body.Close()
+	}
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"day03es/types"
+)
+
+// Number of values returned per facet
+const facetSize = 10
+
+// Keyword field counted by each terms facet
+var facetFields = map[string]string{
+	types.FacetCategory: "category",
+	types.FacetArea:     "area",
+	types.FacetStreet:   "address_parsed.street.keyword",
+	types.FacetChain:    "chain",
+}
+
+// Queries behind the values of the has_phone facet
+var hasPhoneQueries = map[string]interface{}{
+	"true": map[string]interface{}{
+		"exists": map[string]interface{}{"field": "phones"},
+	},
+	"false": map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must_not": map[string]interface{}{
+				"exists": map[string]interface{}{"field": "phones"},
+			},
+		},
+	},
+}
+
+// Query clauses of the selected facet values, except those of the skipped facet
+func facetClauses(f types.Filter, skip string) []interface{} {
+	clauses := make([]interface{}, 0)
+	for _, name := range types.FacetNames {
+		values := f.Facets[name]
+		if name == skip || len(values) == 0 {
+			continue
+		}
+
+		queries := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			if name == types.FacetHasPhone {
+				// Anything but true or false matches nothing
+				q, ok := hasPhoneQueries[value]
+				if !ok {
+					q = map[string]interface{}{"match_none": struct{}{}}
+				}
+				queries = append(queries, q)
+			} else {
+				queries = append(queries, map[string]interface{}{
+					"term": map[string]interface{}{facetFields[name]: value},
+				})
+			}
+		}
+
+		if f.AllValues {
+			clauses = append(clauses, queries...)
+		} else {
+			clauses = append(clauses, map[string]interface{}{
+				"bool": map[string]interface{}{"should": queries, "minimum_should_match": 1},
+			})
+		}
+	}
+	return clauses
+}
+
+// Aggregation counting the values of a facet
+func facetAgg(name string) map[string]interface{} {
+	if name == types.FacetHasPhone {
+		return map[string]interface{}{
+			"filters": map[string]interface{}{"filters": hasPhoneQueries},
+		}
+	}
+	terms := map[string]interface{}{
+		"field": facetFields[name],
+		"size":  facetSize,
+	}
+	// A name with a single place is no chain
+	if name == types.FacetChain {
+		terms["min_doc_count"] = 2
+	}
+	return map[string]interface{}{"terms": terms}
+}
+
+// Facets counts the values of every facet among the places matching the
+// text and the filter. With any-value matching the selection of a facet
+// does not narrow its own counts, so the other values stay selectable.
+func (s *ElasticStore) Facets(text string, filter types.Filter) (map[string][]types.FacetValue, error) {
+	aggs := make(map[string]interface{}, len(types.FacetNames))
+	for _, name := range types.FacetNames {
+		skip := name
+		if filter.AllValues {
+			skip = ""
+		}
+		aggs[name] = map[string]interface{}{
+			"filter": map[string]interface{}{
+				"bool": map[string]interface{}{"filter": facetClauses(filter, skip)},
+			},
+			"aggs": map[string]interface{}{"values": facetAgg(name)},
+		}
+	}
+
+	base := map[string]interface{}{
+		"filter": fieldClauses(filter),
+	}
+	if text != "" {
+		base["must"] = searchQuery(text)
+	}
+	query := map[string]interface{}{
+		"size":  0,
+		"query": map[string]interface{}{"bool": base},
+		"aggs":  aggs,
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex("places"),
+		s.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("Facets: %s", res.String())
+	}
+
+	var result struct {
+		Aggregations map[string]struct {
+			Values struct {
+				Buckets json.RawMessage `json:"buckets"`
+			} `json:"values"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	facets := make(map[string][]types.FacetValue, len(types.FacetNames))
+	for _, name := range types.FacetNames {
+		buckets := result.Aggregations[name].Values.Buckets
+		values := make([]types.FacetValue, 0)
+		facets[name] = values
+		if len(buckets) == 0 {
+			continue
+		}
+
+		// A filters aggregation returns its buckets by name
+		if name == types.FacetHasPhone {
+			var named map[string]struct {
+				DocCount int `json:"doc_count"`
+			}
+			if err := json.Unmarshal(buckets, &named); err != nil {
+				return nil, err
+			}
+			for _, value := range []string{"true", "false"} {
+				values = append(values, types.FacetValue{Value: value, Count: named[value].DocCount})
+			}
+		} else {
+			var terms []struct {
+				Key      string `json:"key"`
+				DocCount int    `json:"doc_count"`
+			}
+			if err := json.Unmarshal(buckets, &terms); err != nil {
+				return nil, err
+			}
+			for _, b := range terms {
+				values = append(values, types.FacetValue{Value: b.Key, Count: b.DocCount})
+			}
+		}
+		facets[name] = values
+	}
+	return facets, nil
+}
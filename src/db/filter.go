@@ -0,0 +1,136 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"day03es/hours"
+	"day03es/phone"
+	"day03es/types"
+)
+
+// Build the ES query clauses of a filter
+func filterClauses(f types.Filter) []interface{} {
+	return append(fieldClauses(f), facetClauses(f, "")...)
+}
+
+// Query clauses of the filter fields that are not facets
+func fieldClauses(f types.Filter) []interface{} {
+	clauses := make([]interface{}, 0)
+	if f.City != "" {
+		clauses = append(clauses, matchAll("address_parsed.city", f.City))
+	}
+	if f.Street != "" {
+		clauses = append(clauses, matchAll("address_parsed.street", f.Street))
+	}
+	if f.Category != "" {
+		clauses = append(clauses, map[string]interface{}{
+			"term": map[string]interface{}{"category": strings.ToLower(f.Category)},
+		})
+	}
+	if !f.OpenAt.IsZero() {
+		// Only places known to be closed are left out, most places have
+		// no opening hours at all
+		clauses = append(clauses, map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					map[string]interface{}{
+						"term": map[string]interface{}{"open_minutes": hours.WeekMinute(f.OpenAt, hours.DefaultLocation)},
+					},
+					map[string]interface{}{
+						"bool": map[string]interface{}{
+							"must_not": map[string]interface{}{
+								"exists": map[string]interface{}{"field": "open_minutes"},
+							},
+						},
+					},
+				},
+				"minimum_should_match": 1,
+			},
+		})
+	}
+	if f.Phone != "" {
+		// A number that can not be normalized matches nothing
+		clauses = append(clauses, map[string]interface{}{
+			"term": map[string]interface{}{"phones": phone.Normalize(f.Phone)},
+		})
+	}
+	if len(f.ExcludeIDs) > 0 {
+		clauses = append(clauses, map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must_not": map[string]interface{}{"ids": map[string]interface{}{"values": f.ExcludeIDs}},
+			},
+		})
+	}
+	return clauses
+}
+
+// Match query that requires every word of the text
+func matchAll(field, text string) map[string]interface{} {
+	return map[string]interface{}{
+		"match": map[string]interface{}{
+			field: map[string]interface{}{
+				"query":    text,
+				"operator": "and",
+			},
+		},
+	}
+}
+
+// Get a page of places matching the filter directly from ES
+func (s *ElasticStore) filterPlaces(limit int, offset int, filter types.Filter) ([]Place, int, error) {
+	if offset < 0 || limit < 0 {
+		return nil, 0, types.ErrInvalidPage
+	}
+
+	query := map[string]interface{}{
+		"from": offset,
+		"size": limit,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": filterClauses(filter),
+			},
+		},
+		"sort": []interface{}{"_doc"},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, 0, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex("places"),
+		s.client.Search.WithBody(&buf),
+		s.client.Search.WithTrackTotalHits(true),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, 0, fmt.Errorf("filterPlaces: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []Place `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+
+	total := result.Hits.Total.Value
+	if offset >= total && total > 0 {
+		return nil, 0, types.ErrInvalidPage
+	}
+	return result.Hits.Hits, total, nil
+}
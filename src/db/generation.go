@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// How long a fetched index generation is trusted before asking ES again
+const generationTTL = 30 * time.Second
+
+// Cached index generation
+var (
+	genMu      sync.Mutex
+	generation string
+	genFetched time.Time
+)
+
+// SetGeneration stores a new generation in the index mapping metadata.
+// It is called after every successful (re)import of the data.
+func (s *ElasticStore) SetGeneration() error {
+	gen := strconv.FormatInt(time.Now().UnixNano(), 10)
+	body := fmt.Sprintf(`{"_meta": {"generation": %q}}`, gen)
+
+	req := esapi.IndicesPutMappingRequest{
+		Index: []string{"places"},
+		Body:  strings.NewReader(body),
+	}
+	res, err := req.Do(context.Background(), s.client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("SetGeneration: %s", res.String())
+	}
+
+	genMu.Lock()
+	generation, genFetched = gen, time.Now()
+	genMu.Unlock()
+	// The cached page data has the places of the old index
+	allPlaces.Store(nil)
+	return nil
+}
+
+// Generation returns an identifier that changes every time the index is rebuilt
+func (s *ElasticStore) Generation() (string, error) {
+	genMu.Lock()
+	defer genMu.Unlock()
+	if genFetched.IsZero() || time.Since(genFetched) > generationTTL {
+		gen, err := s.fetchGeneration()
+		if err != nil {
+			return "", err
+		}
+		// Drop the cached page data when the index was rebuilt
+		if gen != generation {
+			allPlaces.Store(nil)
+		}
+		generation, genFetched = gen, time.Now()
+	}
+	return generation, nil
+}
+
+// Read the generation from the index mapping metadata
+func (s *ElasticStore) fetchGeneration() (string, error) {
+	req := esapi.IndicesGetMappingRequest{Index: []string{"places"}}
+	res, err := req.Do(context.Background(), s.client)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", fmt.Errorf("fetchGeneration: %s", res.String())
+	}
+
+	var result map[string]struct {
+		Mappings struct {
+			Meta struct {
+				Generation string `json:"generation"`
+			} `json:"_meta"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	for _, index := range result {
+		return index.Mappings.Meta.Generation, nil
+	}
+	return "", nil
+}
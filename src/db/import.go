@@ -0,0 +1,453 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+
+	"day03es/address"
+	"day03es/category"
+	"day03es/hours"
+	"day03es/importer"
+	"day03es/phone"
+	"day03es/translit"
+	"day03es/types"
+)
+
+// ImportPolicy decides what happens to rows that fail validation
+type ImportPolicy string
+
+const (
+	// Index the valid rows and report the rejected ones
+	PolicySkip ImportPolicy = "skip"
+	// Do not write anything if a single row is rejected
+	PolicyAbort ImportPolicy = "abort"
+)
+
+// ErrImportAborted is returned when PolicyAbort stops an import
+var ErrImportAborted = errors.New("import aborted, invalid rows found")
+
+// ImportOptions configures an import
+type ImportOptions struct {
+	DryRun bool
+	Policy ImportPolicy
+
+	// Only send the changes compared to the index, and delete the
+	// places of this source that are gone
+	Sync bool
+
+	// Reader of the source format, the tab separated dataset if nil
+	Importer importer.Importer
+
+	// Rules that assign categories to the places, none if nil
+	Categories *category.Classifier
+}
+
+// Reject describes a row that was not indexed
+type Reject struct {
+	Line    int      `json:"line"`
+	ID      string   `json:"id"`
+	Reasons []string `json:"reasons"`
+}
+
+// ImportReport summarizes an import or a dry run
+type ImportReport struct {
+	Source  string   `json:"source"`
+	Format  string   `json:"format"`
+	DryRun  bool     `json:"dry_run"`
+	Total   int      `json:"total"`
+	Valid   int      `json:"valid"`
+	Indexed int      `json:"indexed"`
+	Failed  int      `json:"failed"`
+	Merged  int      `json:"merged"`
+	Rejects []Reject `json:"rejects"`
+
+	// Rows indexed with a problem, e.g. opening hours that were not understood
+	Warnings []Reject `json:"warnings"`
+
+	// Differences found by a sync
+	Diff *SyncDiff `json:"diff,omitempty"`
+
+	mu sync.Mutex
+}
+
+// Add a rejected row to the report
+func (r *ImportReport) reject(line int, id string, reasons ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Rejects = append(r.Rejects, Reject{Line: line, ID: id, Reasons: reasons})
+}
+
+// Add a warning about a row that is indexed anyway
+func (r *ImportReport) warn(line int, id string, reasons ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Warnings = append(r.Warnings, Reject{Line: line, ID: id, Reasons: reasons})
+}
+
+// Order the rejects by line number
+func (r *ImportReport) sortRejects() {
+	sort.Slice(r.Rejects, func(i, j int) bool { return r.Rejects[i].Line < r.Rejects[j].Line })
+	sort.Slice(r.Warnings, func(i, j int) bool { return r.Warnings[i].Line < r.Warnings[j].Line })
+}
+
+// WriteReport writes the report as "json" (summary and rejects) or
+// "csv" (one line per reject)
+func (r *ImportReport) WriteReport(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"line", "id", "reasons"}); err != nil {
+			return err
+		}
+		for _, rej := range r.Rejects {
+			if err := cw.Write([]string{strconv.Itoa(rej.Line), rej.ID, strings.Join(rej.Reasons, "; ")}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown report format '%s'", format)
+}
+
+// Import reads every row of the source, validates it and, unless this
+// is a dry run, indexes the valid ones according to the policy
+func (s *ElasticStore) Import(path string, opts ImportOptions) (*ImportReport, error) {
+	imp := opts.Importer
+	if imp == nil {
+		imp = &importer.Delimited{Comma: '\t', Columns: importer.DefaultColumns}
+	}
+
+	report := &ImportReport{Source: path, DryRun: opts.DryRun, Rejects: make([]Reject, 0), Warnings: make([]Reject, 0)}
+	defer report.sortRejects()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+
+	rows, err := imp.Read(file)
+	report.Format = imp.Format()
+	if err != nil {
+		return report, err
+	}
+	report.Total = len(rows)
+
+	// A row that is rejected is still in the source, a sync must not
+	// delete the place it had indexed before
+	inSource := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		if row.ID != "" {
+			inSource[row.ID] = true
+		}
+	}
+	valid := validateRows(rows, report)
+
+	if opts.DryRun && !opts.Sync {
+		return report, nil
+	}
+
+	// Every document records where it came from
+	ctx := &importContext{
+		prov: provenance{
+			Format:     imp.Format(),
+			File:       filepath.Base(path),
+			ImportedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+		categories: opts.Categories,
+	}
+
+	// Places merged into another one must not come back
+	records, err := s.loadMerges()
+	if err != nil {
+		return report, err
+	}
+	ctx.addMerges(records)
+	valid = ctx.skipMerged(valid, report)
+
+	// Re-indexed places keep their rating and visits
+	if ctx.ratings, err = s.fetchRatings(""); err != nil {
+		return report, err
+	}
+
+	if ctx.visits, err = s.fetchVisits(); err != nil {
+		return report, err
+	}
+
+	var items []bulkItem
+	if opts.Sync {
+		// A dry sync still reads the index to show the diff
+		if items, err = s.diffRows(valid, inSource, ctx, report); err != nil {
+			return report, err
+		}
+	} else {
+		items = indexItems(valid, ctx)
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+	if opts.Policy == PolicyAbort && len(report.Rejects) > 0 {
+		return report, ErrImportAborted
+	}
+	// A sync without changes leaves the index and the caches alone
+	if len(items) == 0 {
+		return report, nil
+	}
+	if err := s.runBulk(items, report); err != nil {
+		return report, err
+	}
+
+	// Mark the index as rebuilt so cached responses get invalidated
+	if err := s.SetGeneration(); err != nil {
+		return report, fmt.Errorf("updating index generation: %w", err)
+	}
+	return report, nil
+}
+
+// Origin of an indexed document
+type provenance struct {
+	Format     string `json:"format"`
+	File       string `json:"file"`
+	Ref        string `json:"ref,omitempty"`
+	ImportedAt string `json:"imported_at"`
+}
+
+// State shared by the documents of one import
+type importContext struct {
+	prov       provenance
+	categories *category.Classifier
+
+	// Ids of the places merged into another one
+	merged map[string]bool
+	// Names and ids of the places merged into each canonical place
+	aliases   map[string][]string
+	mergedIDs map[string][]string
+
+	// Ratings computed from the reviews
+	ratings map[string]types.Rating
+	// Numbers of check-ins
+	visits map[string]int
+}
+
+// Remember the merges done through the admin API
+func (c *importContext) addMerges(records []mergeRecord) {
+	c.merged = make(map[string]bool, len(records))
+	c.aliases = make(map[string][]string)
+	c.mergedIDs = make(map[string][]string)
+	for _, rec := range records {
+		c.merged[rec.ID] = true
+		c.aliases[rec.CanonicalID] = append(c.aliases[rec.CanonicalID], rec.Name)
+		c.mergedIDs[rec.CanonicalID] = append(c.mergedIDs[rec.CanonicalID], rec.ID)
+	}
+}
+
+// Drop the rows of places that were merged into another one
+func (c *importContext) skipMerged(rows []importer.Row, report *ImportReport) []importer.Row {
+	kept := rows[:0]
+	for _, row := range rows {
+		if c.merged[row.ID] {
+			report.Merged++
+			continue
+		}
+		kept = append(kept, row)
+	}
+	return kept
+}
+
+// Check the rows and return the valid ones, rejects go into the report
+func validateRows(rows []importer.Row, report *ImportReport) []importer.Row {
+	valid := make([]importer.Row, 0, len(rows))
+	seen := make(map[string]int)
+	for _, row := range rows {
+		// A record that could not be read is not checked any further
+		if len(row.Problems) > 0 {
+			report.reject(row.Line, row.ID, row.Problems...)
+			continue
+		}
+		reasons := validateRow(row)
+		if first, ok := seen[row.ID]; ok && row.ID != "" {
+			reasons = append(reasons, fmt.Sprintf("duplicate id, first seen on line %d", first))
+		}
+		if len(reasons) > 0 {
+			report.reject(row.Line, row.ID, reasons...)
+			continue
+		}
+		seen[row.ID] = row.Line
+		valid = append(valid, row)
+
+		// Such a place is indexed without a schedule
+		if row.OpeningHours != "" {
+			if _, err := hours.Parse(row.OpeningHours); err != nil {
+				report.warn(row.Line, row.ID, "opening hours not understood: "+err.Error())
+			}
+		}
+	}
+	report.Valid = len(valid)
+	return valid
+}
+
+// Return the reasons why a single row is invalid
+func validateRow(row importer.Row) []string {
+	reasons := make([]string, 0)
+	if row.ID == "" {
+		reasons = append(reasons, "empty id")
+	}
+	if row.Name == "" {
+		reasons = append(reasons, "empty name")
+	}
+	if lat, err := strconv.ParseFloat(row.Lat, 64); err != nil {
+		reasons = append(reasons, fmt.Sprintf("invalid latitude '%s'", row.Lat))
+	} else if lat < -90 || lat > 90 {
+		reasons = append(reasons, fmt.Sprintf("latitude %s out of range", row.Lat))
+	}
+	if lon, err := strconv.ParseFloat(row.Lon, 64); err != nil {
+		reasons = append(reasons, fmt.Sprintf("invalid longitude '%s'", row.Lon))
+	} else if lon < -180 || lon > 180 {
+		reasons = append(reasons, fmt.Sprintf("longitude %s out of range", row.Lon))
+	}
+	return reasons
+}
+
+// Version of the document layout, part of the content hash so that
+// a change in how documents are built re-indexes every place on sync
+const documentVersion = "5"
+
+// Hash of the source content of a row and of the rules applied to it
+func (c *importContext) contentHash(row importer.Row) string {
+	h := sha256.New()
+	for _, v := range []string{documentVersion, c.categories.Version(), row.ID, row.Name, row.Address, row.Phone, row.Lat, row.Lon, row.OpeningHours} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Build the document stored in the index for a row
+func (c *importContext) document(row importer.Row) map[string]interface{} {
+	prov := c.prov
+	prov.Ref = row.Ref
+	addr := address.Parse(row.Address)
+	doc := map[string]interface{}{
+		"name":           row.Name,
+		"address":        row.Address,
+		"name_folded":    translit.Fold(row.Name),
+		"chain":          translit.Fold(row.Name),
+		"address_folded": translit.Fold(row.Address),
+		"address_parsed": addr,
+		"area":           addr.Area(),
+		"phone":          row.Phone,
+		"phones":         phone.NormalizeList(row.Phone),
+		"category":       c.categories.Classify(row.Name),
+		"location":       map[string]interface{}{"lat": row.Lat, "lon": row.Lon},
+		"source":         prov,
+		"content_hash":   c.contentHash(row),
+		"updated_at":     prov.ImportedAt,
+	}
+	if row.OpeningHours != "" {
+		doc["opening_hours"] = row.OpeningHours
+		if schedule, err := hours.Parse(row.OpeningHours); err == nil {
+			doc["open_minutes"] = schedule.Ranges()
+		}
+	}
+	if rating, ok := c.ratings[row.ID]; ok {
+		doc["rating"] = rating
+	}
+	if visits, ok := c.visits[row.ID]; ok {
+		doc["visits"] = visits
+	}
+	if aliases, ok := c.aliases[row.ID]; ok {
+		doc["aliases"] = aliases
+		doc["merged_ids"] = c.mergedIDs[row.ID]
+	}
+	return doc
+}
+
+// One action of a bulk request
+type bulkItem struct {
+	Action string // index, update or delete
+	ID     string
+	Line   int
+	Body   interface{} // nil for delete
+}
+
+// Index actions that write every row
+func indexItems(rows []importer.Row, ctx *importContext) []bulkItem {
+	items := make([]bulkItem, len(rows))
+	for i, row := range rows {
+		items[i] = bulkItem{Action: "index", ID: row.ID, Line: row.Line, Body: ctx.document(row)}
+	}
+	return items
+}
+
+// Run bulk actions on the places index, failed ones are added to the report
+func (s *ElasticStore) runBulk(items []bulkItem, report *ImportReport) error {
+	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Index:         "places",         // index name
+		Client:        s.client,         // Elasticsearch client
+		NumWorkers:    2,                // The number of worker goroutines
+		FlushBytes:    1024 * 1024,      // The flush threshold in bytes
+		FlushInterval: 30 * time.Second, // The periodic flush interval
+	})
+	if err != nil {
+		return fmt.Errorf("creating the indexer: %w", err)
+	}
+
+	for _, it := range items {
+		item := esutil.BulkIndexerItem{
+			Action:     it.Action,
+			DocumentID: it.ID,
+		}
+		if it.Body != nil {
+			jsonData, err := json.Marshal(it.Body)
+			if err != nil {
+				report.reject(it.Line, it.ID, err.Error())
+				continue
+			}
+			item.Body = bytes.NewReader(jsonData)
+		}
+
+		line := it.Line
+		// OnFailure is called for each failed operation
+		item.OnFailure = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+			if err != nil {
+				report.reject(line, item.DocumentID, err.Error())
+			} else {
+				report.reject(line, item.DocumentID, fmt.Sprintf("%s: %s", res.Error.Type, res.Error.Reason))
+			}
+		}
+
+		if err := bi.Add(context.Background(), item); err != nil {
+			return err
+		}
+	}
+
+	if err := bi.Close(context.Background()); err != nil {
+		return err
+	}
+	stats := bi.Stats()
+	report.Indexed = int(stats.NumFlushed)
+	report.Failed = int(stats.NumFailed)
+	return nil
+}
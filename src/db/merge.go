@@ -0,0 +1,272 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"day03es/translit"
+	"day03es/types"
+)
+
+// ErrMergeIntoSelf is returned when a place is listed as its own duplicate
+var ErrMergeIntoSelf = errors.New("a place can not be merged into itself")
+
+// Index keeping track of places merged into another one
+const mergesIndex = "place_merges"
+
+// Longest chain of merges followed when resolving an old id
+const maxMergeHops = 10
+
+// Record of a place merged into a canonical one
+type mergeRecord struct {
+	ID          string `json:"-"`
+	CanonicalID string `json:"canonical_id"`
+	Name        string `json:"name"`
+	MergedAt    string `json:"merged_at"`
+}
+
+// GetPlace returns a single place by id
+func (s *ElasticStore) GetPlace(id string) (*Place, error) {
+	res, err := s.client.Get("places", id, s.client.Get.WithContext(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, types.ErrNotFound
+	} else if res.IsError() {
+		return nil, fmt.Errorf("GetPlace: %s", res.String())
+	}
+
+	var place Place
+	if err := json.NewDecoder(res.Body).Decode(&place); err != nil {
+		return nil, err
+	}
+	return &place, nil
+}
+
+// MergedInto returns the id of the place an old id was merged into,
+// following chains of merges. It returns types.ErrNotFound if the id
+// was never merged.
+func (s *ElasticStore) MergedInto(id string) (string, error) {
+	target := ""
+	for hop := 0; hop < maxMergeHops; hop++ {
+		rec, err := s.getMerge(id)
+		if err == types.ErrNotFound && target != "" {
+			return target, nil
+		} else if err != nil {
+			return "", err
+		}
+		target, id = rec.CanonicalID, rec.CanonicalID
+	}
+	return target, nil
+}
+
+// Read the merge record of an id
+func (s *ElasticStore) getMerge(id string) (*mergeRecord, error) {
+	res, err := s.client.Get(mergesIndex, id, s.client.Get.WithContext(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, types.ErrNotFound
+	} else if res.IsError() {
+		return nil, fmt.Errorf("getMerge: %s", res.String())
+	}
+
+	var doc struct {
+		Source mergeRecord `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	doc.Source.ID = id
+	return &doc.Source, nil
+}
+
+// MergePlaces merges duplicate places into a canonical one. The names of
+// the duplicates become aliases of the canonical place, the duplicates
+// are deleted and their ids redirect to the canonical place, which gets
+// their reviews and check-ins.
+func (s *ElasticStore) MergePlaces(canonicalID string, duplicateIDs []string) (*Place, error) {
+	canonical, err := s.GetPlace(canonicalID)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := canonical.Source.Aliases
+	mergedIDs := canonical.Source.MergedIDs
+	known := map[string]bool{translit.Fold(canonical.Source.Name): true}
+	for _, alias := range aliases {
+		known[translit.Fold(alias)] = true
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	records := make([]mergeRecord, 0, len(duplicateIDs))
+	for _, id := range duplicateIDs {
+		if id == canonicalID {
+			return nil, fmt.Errorf("place %s: %w", id, ErrMergeIntoSelf)
+		}
+		dup, err := s.GetPlace(id)
+		if err != nil {
+			return nil, fmt.Errorf("place %s: %w", id, err)
+		}
+
+		// Keep every distinct spelling of the name
+		for _, name := range append([]string{dup.Source.Name}, dup.Source.Aliases...) {
+			if key := translit.Fold(name); !known[key] {
+				known[key] = true
+				aliases = append(aliases, name)
+			}
+		}
+		mergedIDs = append(append(mergedIDs, id), dup.Source.MergedIDs...)
+		records = append(records, mergeRecord{ID: id, CanonicalID: canonicalID, Name: dup.Source.Name, MergedAt: now})
+	}
+
+	// Write the redirects first, so a failure never loses an id
+	for _, rec := range records {
+		if err := s.putDocument(mergesIndex, rec.ID, rec); err != nil {
+			return nil, err
+		}
+	}
+
+	update := map[string]interface{}{
+		"doc": map[string]interface{}{
+			"aliases":    aliases,
+			"merged_ids": mergedIDs,
+		},
+	}
+	if err := s.updateDocument("places", canonicalID, update); err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		if err := s.deleteDocument("places", rec.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// The ratings and visits of the duplicates count for the canonical place
+	if err := s.moveReviews(canonicalID, duplicateIDs); err != nil {
+		return nil, err
+	}
+	if err := s.moveCheckins(canonicalID, duplicateIDs); err != nil {
+		return nil, err
+	}
+
+	if err := s.SetGeneration(); err != nil {
+		return nil, err
+	}
+	return s.GetPlace(canonicalID)
+}
+
+// Read every merge record, an import must not bring merged places back
+func (s *ElasticStore) loadMerges() ([]mergeRecord, error) {
+	records := make([]mergeRecord, 0)
+	err := s.scanIndex(context.Background(), mergesIndex, func(raw json.RawMessage) error {
+		var hit struct {
+			ID     string      `json:"_id"`
+			Source mergeRecord `json:"_source"`
+		}
+		if err := json.Unmarshal(raw, &hit); err != nil {
+			return err
+		}
+		hit.Source.ID = hit.ID
+		records = append(records, hit.Source)
+		return nil
+	})
+
+	// Nothing was merged yet
+	if err == errNoIndex {
+		return nil, nil
+	}
+	return records, err
+}
+
+// Index a document with a known id and make it visible right away
+func (s *ElasticStore) putDocument(index, id string, doc interface{}) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Index(index, bytes.NewReader(body),
+		s.client.Index.WithContext(context.Background()),
+		s.client.Index.WithDocumentID(id),
+		s.client.Index.WithRefresh("true"),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("putDocument %s/%s: %s", index, id, res.String())
+	}
+	return nil
+}
+
+// Add a document that must not exist yet, errConflict if it does
+func (s *ElasticStore) createDocument(index, id string, doc interface{}) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Create(index, id, bytes.NewReader(body),
+		s.client.Create.WithContext(context.Background()),
+		s.client.Create.WithRefresh("true"),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusConflict {
+		return errConflict
+	} else if res.IsError() {
+		return fmt.Errorf("createDocument %s/%s: %s", index, id, res.String())
+	}
+	return nil
+}
+
+// Apply a partial update to a document
+func (s *ElasticStore) updateDocument(index, id string, update interface{}) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Update(index, id, bytes.NewReader(body),
+		s.client.Update.WithContext(context.Background()),
+		s.client.Update.WithRefresh("true"),
+		s.client.Update.WithRetryOnConflict(3),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return types.ErrNotFound
+	} else if res.IsError() {
+		return fmt.Errorf("updateDocument %s/%s: %s", index, id, res.String())
+	}
+	return nil
+}
+
+// Delete a document, a missing one is not an error
+func (s *ElasticStore) deleteDocument(index, id string) error {
+	res, err := s.client.Delete(index, id,
+		s.client.Delete.WithContext(context.Background()),
+		s.client.Delete.WithRefresh("true"),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("deleteDocument %s/%s: %s", index, id, strings.TrimSpace(res.String()))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"day03es/types"
+)
+
+// How much one event of the history of a user changes the weight of each
+// category of the place
+const (
+	favoriteWeight = 2.0
+	// Per star above or below a neutral 3
+	reviewWeight  = 1.0
+	checkinWeight = 1.0
+)
+
+// Share of the personal affinity in the personalized ranking, the rest
+// is the rank of the candidate
+const personalWeight = 0.5
+
+// Weights closer to zero than this are dropped
+const minPreference = 1e-9
+
+// Add delta to the preference of a user for each category of a place
+func (s *ElasticStore) learn(user string, categories []string, delta float64) error {
+	if len(categories) == 0 || delta == 0 {
+		return nil
+	}
+	_, err := s.updateUserData(user, func(data *types.UserData) (bool, error) {
+		learnInto(&data.Preferences, categories, delta)
+		return true, nil
+	})
+	return err
+}
+
+// Add delta to the weight of each category
+func learnInto(prefs *types.Preferences, categories []string, delta float64) {
+	if prefs.Categories == nil {
+		prefs.Categories = make(map[string]float64)
+	}
+	for _, c := range categories {
+		w := prefs.Categories[c] + delta
+		if math.Abs(w) < minPreference {
+			delete(prefs.Categories, c)
+		} else {
+			prefs.Categories[c] = w
+		}
+	}
+	prefs.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+}
+
+// Change of the preferences for a rating of 1 to 5 stars
+func ratingDelta(rating int) float64 {
+	return float64(rating-3) * reviewWeight
+}
+
+// Affinity of a user to a place from -1 to 1: the weights of its
+// categories relative to the strongest preference of the user
+func affinity(categories []string, prefs map[string]float64) float64 {
+	strongest := 0.0
+	for _, w := range prefs {
+		strongest = math.Max(strongest, math.Abs(w))
+	}
+	if strongest == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, c := range categories {
+		sum += prefs[c]
+	}
+	return math.Max(-1, math.Min(1, sum/strongest))
+}
+
+// Re-rank candidates by their rank and the affinity of the user. Like
+// diversifying, the relevance of a candidate falls linearly with its rank,
+// so the result only depends on the order of the candidates.
+func personalize(places []types.RecPlace, prefs map[string]float64, explain bool) []types.RecPlace {
+	n := len(places)
+	scores := make([]float64, n)
+	for i := range places {
+		a := affinity(places[i].Categories, prefs)
+		scores[i] = (1-personalWeight)*(1-float64(i)/float64(n)) + personalWeight*a
+		if explain && places[i].Score != nil {
+			a = round3(a)
+			places[i].Score.Personal = &a
+		}
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	result := make([]types.RecPlace, n)
+	for i, j := range order {
+		result[i] = places[j]
+	}
+	return result
+}
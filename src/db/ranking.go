@@ -0,0 +1,128 @@
+package db
+
+import (
+	"math"
+	"strconv"
+
+	"day03es/types"
+)
+
+// RankProfiles are the ranking presets of recommendations
+var RankProfiles = map[string]types.RankProfile{
+	// Closest places first, like a plain distance sort
+	types.ModeNearest: {Scale: 500, Distance: 1},
+	// Nearby places, better rated ones move up
+	types.ModeBalanced: {Scale: 1000, Distance: 1, Rating: 1, Reviews: 0.5, Category: 1},
+	// Well rated places within a walk, even if a bit further away
+	types.ModeBest: {Scale: 3000, Distance: 1, Rating: 3, Reviews: 1, Category: 1},
+}
+
+// Rating assumed for places without reviews, out of 5
+const unratedAverage = 3
+
+// Check if only the distance matters, the ranking is then a distance sort
+func distanceOnly(p types.RankProfile, prefer []string) bool {
+	return p.Rating == 0 && p.Reviews == 0 && (p.Category == 0 || len(prefer) == 0)
+}
+
+// Wrap a query into the function score of a ranking profile:
+// gauss(distance) * (distance + rating * average/5 + reviews * log10(1+count) + category * preferred)
+func rankQuery(query map[string]interface{}, origin map[string]interface{}, p types.RankProfile, prefer []string) map[string]interface{} {
+	// Zero weights are left out, ES rejects them
+	functions := make([]interface{}, 0, 4)
+	if p.Distance > 0 {
+		functions = append(functions, map[string]interface{}{"weight": p.Distance})
+	}
+	if p.Rating > 0 {
+		functions = append(functions, map[string]interface{}{
+			"field_value_factor": map[string]interface{}{"field": "rating.average", "factor": 0.2, "missing": unratedAverage},
+			"weight":             p.Rating,
+		})
+	}
+	if p.Reviews > 0 {
+		functions = append(functions, map[string]interface{}{
+			"field_value_factor": map[string]interface{}{"field": "rating.count", "modifier": "log1p", "missing": 0},
+			"weight":             p.Reviews,
+		})
+	}
+	if p.Category > 0 && len(prefer) > 0 {
+		functions = append(functions, map[string]interface{}{
+			"filter": map[string]interface{}{"terms": map[string]interface{}{"category": prefer}},
+			"weight": p.Category,
+		})
+	}
+
+	// A filter only query scores 0, match_all makes it 1
+	clause := query["bool"].(map[string]interface{})
+	clause["must"] = map[string]interface{}{"match_all": struct{}{}}
+
+	return map[string]interface{}{
+		"function_score": map[string]interface{}{
+			"query": map[string]interface{}{
+				"function_score": map[string]interface{}{
+					"query":      query,
+					"functions":  functions,
+					"score_mode": "sum",
+					"boost_mode": "replace",
+				},
+			},
+			"functions": []interface{}{
+				map[string]interface{}{
+					"gauss": map[string]interface{}{
+						"location": map[string]interface{}{"origin": origin, "scale": formatMeters(p.Scale)},
+					},
+				},
+			},
+			"boost_mode": "multiply",
+		},
+	}
+}
+
+func formatMeters(m float64) string {
+	return strconv.FormatFloat(m, 'f', -1, 64) + "m"
+}
+
+// Compute the score of a place the way rankQuery does
+func explainScore(place types.RecPlace, origin types.Location, p types.RankProfile, prefer []string) *types.RecScore {
+	score := &types.RecScore{DistanceM: math.Round(types.Distance(origin, place.Location))}
+
+	// ES gauss decay, 0.5 at the scale distance
+	if p.Scale > 0 {
+		sigma2 := -p.Scale * p.Scale / (2 * math.Log(0.5))
+		score.Decay = math.Exp(-score.DistanceM * score.DistanceM / (2 * sigma2))
+	}
+
+	average, count := float64(unratedAverage), 0.0
+	if place.Rating != nil {
+		average, count = place.Rating.Average, float64(place.Rating.Count)
+	}
+	score.Distance = p.Distance
+	score.Rating = p.Rating * average * 0.2
+	score.Reviews = p.Reviews * math.Log10(1+count)
+	if p.Category > 0 && preferred(place.Categories, prefer) {
+		score.Category = p.Category
+	}
+	score.Total = score.Decay * (score.Distance + score.Rating + score.Reviews + score.Category)
+
+	score.Decay = round3(score.Decay)
+	score.Rating = round3(score.Rating)
+	score.Reviews = round3(score.Reviews)
+	score.Total = round3(score.Total)
+	return score
+}
+
+// Check if a place has one of the preferred categories
+func preferred(categories, prefer []string) bool {
+	for _, c := range categories {
+		for _, p := range prefer {
+			if c == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,422 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"day03es/types"
+)
+
+// Index of the reviews, one document per user and place
+const reviewsIndex = "place_reviews"
+
+// Places whose rating is computed per request of the ratings aggregation
+const ratingsPageSize = 1000
+
+// Mapping of the reviews index
+const reviewsMapping = `
+{
+  "mappings": {
+    "properties": {
+      "place_id":   { "type": "keyword" },
+      "user":       { "type": "keyword" },
+      "rating":     { "type": "integer" },
+      "text":       { "type": "text" },
+      "status":     { "type": "keyword" },
+      "created_at": { "type": "date" },
+      "updated_at": { "type": "date" }
+    }
+  }
+}
+`
+
+// The reviews index is created on the first review
+var reviewsIndexReady = &lazyIndex{name: reviewsIndex, mapping: reviewsMapping}
+
+// ReviewID returns the id of the review of a user for a place, a user
+// has one review per place
+func ReviewID(placeID, user string) string {
+	return placeID + ":" + user
+}
+
+// Index created with its mapping the first time it is written to
+type lazyIndex struct {
+	sync.Mutex
+	name    string
+	mapping string
+	done    bool
+}
+
+// Create an index with its mapping if it does not exist yet
+func (s *ElasticStore) ensureIndex(idx *lazyIndex) error {
+	idx.Lock()
+	defer idx.Unlock()
+	if idx.done {
+		return nil
+	}
+
+	res, err := s.client.Indices.Exists([]string{idx.name})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		req := esapi.IndicesCreateRequest{Index: idx.name, Body: strings.NewReader(idx.mapping)}
+		res, err := req.Do(context.Background(), s.client)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		// Another server may have created it in the meantime
+		if res.IsError() && !strings.Contains(res.String(), "resource_already_exists_exception") {
+			return fmt.Errorf("ensureIndex %s: %s", idx.name, res.String())
+		}
+	}
+	idx.done = true
+	return nil
+}
+
+// PutReview saves the review of a user for a place, replacing their
+// previous one, and updates the rating of the place and the preferences
+// of the user
+func (s *ElasticStore) PutReview(review types.Review) (*types.Review, error) {
+	place, err := s.GetPlace(review.PlaceID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureIndex(reviewsIndexReady); err != nil {
+		return nil, err
+	}
+
+	review.ID = ReviewID(review.PlaceID, review.User)
+	review.Status = types.ReviewPublished
+	review.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	review.CreatedAt = review.UpdatedAt
+	// The user likes the categories of the place as much as the new rating says
+	learned := ratingDelta(review.Rating)
+	if old, err := s.GetReview(review.ID); err == nil {
+		review.CreatedAt = old.CreatedAt
+		// Editing does not undo moderation
+		review.Status = old.Status
+		learned -= ratingDelta(old.Rating)
+	} else if err != types.ErrReviewNotFound {
+		return nil, err
+	}
+
+	if err := s.putDocument(reviewsIndex, review.ID, review); err != nil {
+		return nil, err
+	}
+	if err := s.updateRating(review.PlaceID); err != nil {
+		return nil, err
+	}
+	if err := s.learn(review.User, place.Source.Category, learned); err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// GetReview returns a review by id
+func (s *ElasticStore) GetReview(id string) (*types.Review, error) {
+	res, err := s.client.Get(reviewsIndex, id, s.client.Get.WithContext(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, types.ErrReviewNotFound
+	} else if res.IsError() {
+		return nil, fmt.Errorf("GetReview: %s", res.String())
+	}
+
+	var doc struct {
+		Source types.Review `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc.Source, nil
+}
+
+// ListReviews returns a page of reviews, newest first, and their total.
+// An empty placeID or status matches every place or status.
+func (s *ElasticStore) ListReviews(placeID, status string, limit, offset int) ([]types.Review, int, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, 0, types.ErrInvalidPage
+	}
+
+	clauses := make([]interface{}, 0)
+	if placeID != "" {
+		clauses = append(clauses, map[string]interface{}{"term": map[string]interface{}{"place_id": placeID}})
+	}
+	if status != "" {
+		clauses = append(clauses, map[string]interface{}{"term": map[string]interface{}{"status": status}})
+	}
+	query := map[string]interface{}{
+		"from":  offset,
+		"size":  limit,
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": clauses}},
+		"sort":  []interface{}{map[string]interface{}{"updated_at": "desc"}},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, 0, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex(reviewsIndex),
+		s.client.Search.WithBody(&buf),
+		s.client.Search.WithTrackTotalHits(true),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	// No review was written yet
+	if res.StatusCode == http.StatusNotFound {
+		return []types.Review{}, 0, nil
+	} else if res.IsError() {
+		return nil, 0, fmt.Errorf("ListReviews: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Source types.Review `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+	reviews := make([]types.Review, len(result.Hits.Hits))
+	for i, hit := range result.Hits.Hits {
+		reviews[i] = hit.Source
+	}
+	return reviews, result.Hits.Total.Value, nil
+}
+
+// SetReviewStatus publishes or hides a review
+func (s *ElasticStore) SetReviewStatus(id, status string) (*types.Review, error) {
+	review, err := s.GetReview(id)
+	if err != nil {
+		return nil, err
+	}
+	review.Status = status
+	update := map[string]interface{}{"doc": map[string]interface{}{"status": status}}
+	if err := s.updateDocument(reviewsIndex, id, update); err == types.ErrNotFound {
+		return nil, types.ErrReviewNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if err := s.updateRating(review.PlaceID); err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// DeleteReview deletes a review and updates the rating of its place and
+// the preferences of its user
+func (s *ElasticStore) DeleteReview(id string) error {
+	review, err := s.GetReview(id)
+	if err != nil {
+		return err
+	}
+	if err := s.deleteDocument(reviewsIndex, id); err != nil {
+		return err
+	}
+	if err := s.updateRating(review.PlaceID); err != nil {
+		return err
+	}
+
+	// Forget the rating in the preferences of the user
+	place, err := s.GetPlace(review.PlaceID)
+	if err == types.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return s.learn(review.User, place.Source.Category, -ratingDelta(review.Rating))
+}
+
+// Published reviews of one place, or of every place if placeID is empty
+func publishedReviews(placeID string) map[string]interface{} {
+	clauses := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"status": types.ReviewPublished}},
+	}
+	if placeID != "" {
+		clauses = append(clauses, map[string]interface{}{"term": map[string]interface{}{"place_id": placeID}})
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{"filter": clauses}}
+}
+
+// Compute the ratings of the places from their published reviews. The
+// places are paged through a composite aggregation, so every rated place
+// is included however many there are.
+func (s *ElasticStore) fetchRatings(placeID string) (map[string]types.Rating, error) {
+	ratings := make(map[string]types.Rating)
+	var after interface{}
+	for {
+		composite := map[string]interface{}{
+			"size": ratingsPageSize,
+			"sources": []interface{}{
+				map[string]interface{}{"place": map[string]interface{}{"terms": map[string]interface{}{"field": "place_id"}}},
+			},
+		}
+		if after != nil {
+			composite["after"] = after
+		}
+		query := map[string]interface{}{
+			"size":  0,
+			"query": publishedReviews(placeID),
+			"aggs": map[string]interface{}{
+				"places": map[string]interface{}{
+					"composite": composite,
+					"aggs": map[string]interface{}{
+						"average": map[string]interface{}{"avg": map[string]interface{}{"field": "rating"}},
+					},
+				},
+			},
+		}
+
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(query); err != nil {
+			return nil, err
+		}
+
+		res, err := s.client.Search(
+			s.client.Search.WithContext(context.Background()),
+			s.client.Search.WithIndex(reviewsIndex),
+			s.client.Search.WithBody(&buf),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// No review was written yet
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			return ratings, nil
+		} else if res.IsError() {
+			defer res.Body.Close()
+			return nil, fmt.Errorf("fetchRatings: %s", res.String())
+		}
+
+		var result struct {
+			Aggregations struct {
+				Places struct {
+					AfterKey json.RawMessage `json:"after_key"`
+					Buckets  []struct {
+						Key struct {
+							Place string `json:"place"`
+						} `json:"key"`
+						DocCount int `json:"doc_count"`
+						Average  struct {
+							Value float64 `json:"value"`
+						} `json:"average"`
+					} `json:"buckets"`
+				} `json:"places"`
+			} `json:"aggregations"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		places := result.Aggregations.Places
+		for _, b := range places.Buckets {
+			ratings[b.Key.Place] = types.Rating{Average: roundRating(b.Average.Value), Count: b.DocCount}
+		}
+		if len(places.Buckets) < ratingsPageSize || len(places.AfterKey) == 0 {
+			return ratings, nil
+		}
+		after = places.AfterKey
+	}
+}
+
+// Keep two decimals of an average rating
+func roundRating(v float64) float64 {
+	return float64(int(v*100+0.5)) / 100
+}
+
+// Store the rating of a place on its document
+func (s *ElasticStore) updateRating(placeID string) error {
+	ratings, err := s.fetchRatings(placeID)
+	if err != nil {
+		return err
+	}
+
+	// A place without published reviews has no rating
+	var rating *types.Rating
+	if r, ok := ratings[placeID]; ok {
+		rating = &r
+	}
+	update := map[string]interface{}{"doc": map[string]interface{}{"rating": rating}}
+	if err := s.updateDocument("places", placeID, update); err != nil && err != types.ErrNotFound {
+		return err
+	}
+	updateCachedPlace(placeID, func(source *Source) { source.Rating = rating })
+
+	// Cached responses show the old rating
+	return s.SetActivity()
+}
+
+// Reviews of a merged duplicate read per request when they are moved
+const movedReviewsPage = 100
+
+// Give the reviews of merged duplicates to the canonical place. A user
+// who reviewed both keeps the review they changed last. The rating of the
+// canonical place is computed again.
+func (s *ElasticStore) moveReviews(canonicalID string, duplicateIDs []string) error {
+	moved := false
+	for _, id := range duplicateIDs {
+		// Read them all first, the pages shift while they are deleted
+		var reviews []types.Review
+		for offset := 0; ; offset += movedReviewsPage {
+			page, total, err := s.ListReviews(id, "", movedReviewsPage, offset)
+			if err != nil {
+				return err
+			}
+			reviews = append(reviews, page...)
+			if len(page) == 0 || offset+len(page) >= total {
+				break
+			}
+		}
+
+		for _, review := range reviews {
+			old := ReviewID(id, review.User)
+			review.ID = ReviewID(canonicalID, review.User)
+			review.PlaceID = canonicalID
+			existing, err := s.GetReview(review.ID)
+			if err == types.ErrReviewNotFound || (err == nil && review.UpdatedAt.After(existing.UpdatedAt)) {
+				if err := s.putDocument(reviewsIndex, review.ID, review); err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err
+			}
+			if err := s.deleteDocument(reviewsIndex, old); err != nil {
+				return err
+			}
+			moved = true
+		}
+	}
+	if !moved {
+		return nil
+	}
+	return s.updateRating(canonicalID)
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"day03es/translit"
+	"day03es/types"
+)
+
+// Search returns a page of places matching a full text query in the name
+// or address, and the total number of matches. The query may be typed in
+// Cyrillic or in any common Latin transliteration. Results are ordered by
+// relevance, or by the number of check-ins with types.SortPopular.
+func (s *ElasticStore) Search(text string, filter types.Filter, sort string, limit int, offset int) ([]Place, int, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, 0, types.ErrInvalidPage
+	}
+
+	query := map[string]interface{}{
+		"from": offset,
+		"size": limit,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must":   searchQuery(text),
+				"filter": filterClauses(filter),
+			},
+		},
+	}
+	if sort == types.SortPopular {
+		query["sort"] = []interface{}{
+			map[string]interface{}{"visits": map[string]interface{}{"order": "desc", "missing": 0}},
+			"_score",
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, 0, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex("places"),
+		s.client.Search.WithBody(&buf),
+		s.client.Search.WithTrackTotalHits(true),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, 0, fmt.Errorf("Search: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []Place `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+	return result.Hits.Hits, result.Hits.Total.Value, nil
+}
+
+// Query matching a text in any script in the name, aliases or address
+func searchQuery(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{
+					"multi_match": map[string]interface{}{
+						"query":    text,
+						"fields":   []string{"name^2", "aliases", "address"},
+						"operator": "and",
+					},
+				},
+				map[string]interface{}{
+					"multi_match": map[string]interface{}{
+						"query":     translit.Fold(text),
+						"fields":    []string{"name_folded^2", "address_folded"},
+						"operator":  "and",
+						"fuzziness": "AUTO",
+					},
+				},
+			},
+			"minimum_should_match": 1,
+		},
+	}
+}
@@ -1,6 +1,13 @@
 package db
 
-import "day03es/types"
+import (
+	"context"
+	"time"
+
+	"day03es/address"
+	"day03es/tiles"
+	"day03es/types"
+)
 
 // Place represents a location entry.
 type Place struct {
@@ -11,20 +18,128 @@ type Place struct {
 }
 
 type Source struct {
-	Address  string `json:"address"`
-	Location struct {
+	Address       string          `json:"address"`
+	AddressParsed address.Address `json:"address_parsed"`
+	Location      struct {
 		Lat string `json:"lat"`
 		Lon string `json:"lon"`
 	} `json:"location"`
-	Name  string `json:"name"`
-	Phone string `json:"phone"`
+	Name   string   `json:"name"`
+	Phone  string   `json:"phone"`
+	Phones []string `json:"phones"`
+
+	// Number of check-ins
+	Visits int `json:"visits"`
+
+	// Kinds of venue inferred from the name
+	Category []string `json:"category"`
+	// Locality, settlement or city of the address
+	Area string `json:"area"`
+	// In the OSM opening_hours syntax
+	OpeningHours string `json:"opening_hours"`
+	// Aggregate of the published reviews
+	Rating *types.Rating `json:"rating"`
+
+	// Names and ids of duplicates merged into this place
+	Aliases   []string `json:"aliases"`
+	MergedIDs []string `json:"merged_ids"`
+
+	// Import bookkeeping
+	Origin struct {
+		Format string `json:"format"`
+		File   string `json:"file"`
+		Ref    string `json:"ref"`
+	} `json:"source"`
+	ContentHash string `json:"content_hash"`
+	UpdatedAt   string `json:"updated_at"`
 }
 
 // Store defines methods for interacting with the database.
 type Store interface {
-	// returns a list of items, a total number of hits and (or) an error in case of one
-	GetPlaces(limit int, offset int) ([]Place, int, error)
+	// returns a list of items matching the filter, a total number of hits and (or) an error in case of one
+	GetPlaces(limit int, offset int, filter types.Filter) ([]Place, int, error)
+
+	// returns a list of closest places matching the filter based on specified location
+	GetRecommended(lat, lon float64, filter types.Filter, opts types.RecOptions) ([]types.RecPlace, error)
+
+	// returns the number of places matching the filter in each category
+	CategoryCounts(filter types.Filter) (map[string]int, error)
+
+	// returns the most common values of each facet among the places matching a text query (all places if empty) and the filter
+	Facets(text string, filter types.Filter) (map[string][]types.FacetValue, error)
+
+	// returns an identifier that changes every time the index is rebuilt
+	Generation() (string, error)
+
+	// returns an identifier that changes with the ratings and visits of the places
+	ActivityGeneration() (string, error)
+
+	// calls fn for every place in the index without loading them all into memory
+	ScanPlaces(ctx context.Context, fn func(Place) error) error
+
+	// returns completions of a partially typed name, nearby places first if loc is set
+	Suggest(prefix string, limit int, loc *types.Location) ([]types.Suggestion, error)
+
+	// returns a page of places matching a text query in any script, in the order given by sort, and the total number of matches
+	Search(text string, filter types.Filter, sort string, limit int, offset int) ([]Place, int, error)
+
+	// returns a single place, types.ErrNotFound if there is none with this id
+	GetPlace(id string) (*Place, error)
+
+	// returns the id of the place an old id was merged into, types.ErrNotFound if it was not merged
+	MergedInto(id string) (string, error)
+
+	// merges duplicates into a canonical place and returns the result
+	MergePlaces(canonicalID string, duplicateIDs []string) (*Place, error)
+
+	// saves the review of a user for a place, replacing their previous one
+	PutReview(review types.Review) (*types.Review, error)
+
+	// returns a review, types.ErrReviewNotFound if there is none with this id
+	GetReview(id string) (*types.Review, error)
+
+	// returns a page of reviews of a place (every place if empty) with a status (any if empty) and their total
+	ListReviews(placeID, status string, limit, offset int) ([]types.Review, int, error)
+
+	// publishes or hides a review
+	SetReviewStatus(id, status string) (*types.Review, error)
+
+	// deletes a review
+	DeleteReview(id string) error
+
+	// returns the places with the given ids in the same order, skipping the ids of deleted places
+	GetPlacesByID(ids []string) ([]Place, error)
+
+	// returns the favorites and lists of a user, empty if they saved nothing
+	GetUserData(user string) (*types.UserData, error)
+
+	// adds a place to the favorites of a user
+	AddFavorite(user, placeID string) (*types.UserData, error)
+
+	// removes a place from the favorites of a user
+	RemoveFavorite(user, placeID string) (*types.UserData, error)
+
+	// creates a list of a user if it has no id, replaces it otherwise
+	PutList(user string, list types.PlaceList) (*types.PlaceList, error)
+
+	// deletes a list of a user
+	DeleteList(user, listID string) error
+
+	// returns a shared list by its share id, types.ErrListNotFound if there is none or it is not shared
+	GetSharedList(shareID string) (*types.PlaceList, error)
+
+	// records a visit, types.ErrTooFar if its location is farther than maxDistance meters from the place, types.ErrTooSoon if the user already checked in there in the same period of interval
+	AddCheckin(checkin types.Checkin, maxDistance float64, interval time.Duration) (*types.Checkin, error)
+
+	// returns a page of the check-ins of a user, newest first, and their total
+	ListCheckins(user string, limit, offset int) ([]types.Checkin, int, error)
+
+	// returns the clusters of places in a box per map tile at a precision, and the places of the tiles with fewer than threshold
+	Clusters(bbox tiles.BBox, precision int, filter types.Filter, threshold int) ([]types.Cluster, []types.MapPlace, error)
+
+	// returns a Mapbox Vector Tile of the places in a map tile
+	VectorTile(t tiles.Tile) ([]byte, error)
 
-	// returns a list of closest places based on specified location
-	GetRecommended(lat, lon float64) ([]types.RecPlace, error)
+	// returns the number of places in each map tile of a box at a precision, with their mean rating if withRating
+	Density(bbox tiles.BBox, precision int, filter types.Filter, withRating bool) ([]types.DensityCell, error)
 }
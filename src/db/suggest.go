@@ -0,0 +1,133 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"day03es/translit"
+	"day03es/types"
+)
+
+// Maximum number of suggestions returned at once
+const suggestMax = 20
+
+// Distance at which the location bias of a suggestion halves
+const suggestScale = "2km"
+
+// Suggest returns completions of a partially typed place name or address.
+// If loc is not nil, places near it are ranked first.
+func (s *ElasticStore) Suggest(prefix string, limit int, loc *types.Location) ([]types.Suggestion, error) {
+	if limit <= 0 || limit > suggestMax {
+		limit = suggestMax
+	}
+
+	// Match the text as typed and its folded form, so that Cyrillic
+	// and other transliterations find the same places
+	var query interface{} = map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should": []interface{}{
+				prefixQuery(prefix, "name.suggest", "address.suggest"),
+				prefixQuery(translit.Fold(prefix), "name_folded", "address_folded"),
+			},
+			"minimum_should_match": 1,
+		},
+	}
+
+	// Bias the text score by the distance from the given point
+	if loc != nil {
+		query = map[string]interface{}{
+			"function_score": map[string]interface{}{
+				"query": query,
+				"functions": []interface{}{
+					map[string]interface{}{
+						"gauss": map[string]interface{}{
+							"location": map[string]interface{}{
+								"origin": map[string]float64{"lat": loc.Lat, "lon": loc.Lon},
+								"scale":  suggestScale,
+							},
+						},
+					},
+				},
+				"boost_mode": "multiply",
+			},
+		}
+	}
+
+	// Ask for more hits than needed, chains share the same name
+	body := map[string]interface{}{
+		"size":    limit * 3,
+		"query":   query,
+		"_source": []string{"name", "address"},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex("places"),
+		s.client.Search.WithBody(&buf),
+		s.client.Search.WithTrackTotalHits(false),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("Suggest: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []Place `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	// Keep one suggestion per distinct name
+	suggestions := make([]types.Suggestion, 0, limit)
+	seen := make(map[string]bool)
+	for _, hit := range result.Hits.Hits {
+		key := strings.ToLower(hit.Source.Name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		suggestions = append(suggestions, types.Suggestion{
+			ID:      types.NumericID(hit.ID),
+			PlaceID: hit.ID,
+			Name:    hit.Source.Name,
+			Address: hit.Source.Address,
+		})
+		if len(suggestions) == limit {
+			break
+		}
+	}
+	return suggestions, nil
+}
+
+// Build a bool_prefix query over search_as_you_type fields, name matches weigh more
+func prefixQuery(text, nameField, addressField string) map[string]interface{} {
+	return map[string]interface{}{
+		"multi_match": map[string]interface{}{
+			"query": text,
+			"type":  "bool_prefix",
+			"fields": []string{
+				nameField + "^3",
+				nameField + "._2gram^3",
+				nameField + "._3gram^3",
+				addressField,
+				addressField + "._2gram",
+				addressField + "._3gram",
+			},
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+
+	"day03es/importer"
+	"day03es/types"
+)
+
+// SyncDiff lists the ids of the places a sync adds, changes or deletes
+type SyncDiff struct {
+	New       []string `json:"new"`
+	Changed   []string `json:"changed"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// Compare the rows with the documents of the same source in the index
+// and return the bulk actions that bring the index up to date. Only the
+// places whose id is not in the source at all are deleted.
+func (s *ElasticStore) diffRows(rows []importer.Row, inSource map[string]bool, ctx *importContext, report *ImportReport) ([]bulkItem, error) {
+	prov := ctx.prov
+
+	// Content hash of every indexed place that came from this source
+	type indexed struct {
+		hash string
+		ours bool
+	}
+	existing := make(map[string]indexed)
+	err := s.ScanPlaces(context.Background(), func(p Place) error {
+		origin := p.Source.Origin
+		existing[p.ID] = indexed{
+			hash: p.Source.ContentHash,
+			ours: origin.File == prov.File && origin.Format == prov.Format,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &SyncDiff{New: make([]string, 0), Changed: make([]string, 0), Deleted: make([]string, 0)}
+	report.Diff = diff
+
+	items := make([]bulkItem, 0)
+	for _, row := range rows {
+		doc, ok := existing[row.ID]
+		switch {
+		case !ok:
+			diff.New = append(diff.New, row.ID)
+		case doc.hash != ctx.contentHash(row):
+			diff.Changed = append(diff.Changed, row.ID)
+		default:
+			// Nothing to write
+			diff.Unchanged++
+			continue
+		}
+		items = append(items, bulkItem{Action: "index", ID: row.ID, Line: row.Line, Body: ctx.document(row)})
+	}
+
+	// Places imported from other sources, or before provenance was
+	// recorded, are never deleted
+	for id, doc := range existing {
+		if doc.ours && !inSource[id] {
+			diff.Deleted = append(diff.Deleted, id)
+			items = append(items, bulkItem{Action: "delete", ID: id})
+		}
+	}
+
+	types.SortIDs(diff.New)
+	types.SortIDs(diff.Changed)
+	types.SortIDs(diff.Deleted)
+	return items, nil
+}
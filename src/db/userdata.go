@@ -0,0 +1,480 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"day03es/types"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Index of what users saved, one document per user
+const userDataIndex = "user_data"
+
+// Mapping of the user data index
+const userDataMapping = `
+{
+  "mappings": {
+    "properties": {
+      "user":      { "type": "keyword" },
+      "favorites": { "type": "keyword" },
+      "lists": {
+        "properties": {
+          "id":         { "type": "keyword" },
+          "name":       { "type": "text" },
+          "places":     { "type": "keyword" },
+          "shared":     { "type": "boolean" },
+          "share_id":   { "type": "keyword" },
+          "created_at": { "type": "date" },
+          "updated_at": { "type": "date" }
+        }
+      },
+      "preferences": { "type": "object", "enabled": false },
+      "updated_at": { "type": "date" }
+    }
+  }
+}
+`
+
+// The user data index is created on the first save
+var userDataIndexReady = &lazyIndex{name: userDataIndex, mapping: userDataMapping}
+
+// How often a change of the user data is tried again when another
+// request saved the document in between
+const maxUserDataRetries = 5
+
+// Version of a user data document for optimistic concurrency, the zero
+// value means the document does not exist yet
+type docVersion struct {
+	SeqNo       int `json:"_seq_no"`
+	PrimaryTerm int `json:"_primary_term"`
+}
+
+// Saving the user data lost a race with another request
+var errConflict = errors.New("version conflict")
+
+// GetUserData returns what a user saved, empty data if they saved nothing
+func (s *ElasticStore) GetUserData(user string) (*types.UserData, error) {
+	data, _, err := s.getUserData(user)
+	return data, err
+}
+
+// Read the data of a user and the version it was read at
+func (s *ElasticStore) getUserData(user string) (*types.UserData, docVersion, error) {
+	data := &types.UserData{User: user, Favorites: []string{}, Lists: []types.PlaceList{}}
+
+	res, err := s.client.Get(userDataIndex, user, s.client.Get.WithContext(context.Background()))
+	if err != nil {
+		return nil, docVersion{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return data, docVersion{}, nil
+	} else if res.IsError() {
+		return nil, docVersion{}, fmt.Errorf("GetUserData: %s", res.String())
+	}
+
+	var doc struct {
+		docVersion
+		Source types.UserData `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, docVersion{}, err
+	}
+	if doc.Source.Favorites != nil {
+		data.Favorites = doc.Source.Favorites
+	}
+	if doc.Source.Lists != nil {
+		data.Lists = doc.Source.Lists
+	}
+	data.Preferences = doc.Source.Preferences
+	data.UpdatedAt = doc.Source.UpdatedAt
+	return data, doc.docVersion, nil
+}
+
+// Save the data of a user if the document is still at the version it was
+// read at, errConflict otherwise
+func (s *ElasticStore) putUserData(data *types.UserData, version docVersion) error {
+	if err := s.ensureIndex(userDataIndexReady); err != nil {
+		return err
+	}
+	data.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	opts := []func(*esapi.IndexRequest){
+		s.client.Index.WithContext(context.Background()),
+		s.client.Index.WithDocumentID(data.User),
+		s.client.Index.WithRefresh("true"),
+	}
+	if version == (docVersion{}) {
+		// Another request may be creating the document too
+		opts = append(opts, s.client.Index.WithOpType("create"))
+	} else {
+		opts = append(opts,
+			s.client.Index.WithIfSeqNo(version.SeqNo),
+			s.client.Index.WithIfPrimaryTerm(version.PrimaryTerm),
+		)
+	}
+	res, err := s.client.Index(userDataIndex, bytes.NewReader(body), opts...)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusConflict {
+		return errConflict
+	} else if res.IsError() {
+		return fmt.Errorf("putUserData: %s", res.String())
+	}
+	return nil
+}
+
+// Change the data of a user with fn and save it. The change is made again
+// on fresh data when another request saved it in between. fn returns
+// false if there is nothing to save.
+func (s *ElasticStore) updateUserData(user string, fn func(*types.UserData) (bool, error)) (*types.UserData, error) {
+	for attempt := 0; ; attempt++ {
+		data, version, err := s.getUserData(user)
+		if err != nil {
+			return nil, err
+		}
+		changed, err := fn(data)
+		if err != nil || !changed {
+			return data, err
+		}
+		err = s.putUserData(data, version)
+		if err == errConflict && attempt < maxUserDataRetries {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+}
+
+// AddFavorite adds a place to the favorites of a user, who then likes
+// its categories more
+func (s *ElasticStore) AddFavorite(user, placeID string) (*types.UserData, error) {
+	place, err := s.GetPlace(placeID)
+	if err != nil {
+		return nil, err
+	}
+	return s.updateUserData(user, func(data *types.UserData) (bool, error) {
+		for _, id := range data.Favorites {
+			if id == placeID {
+				return false, nil
+			}
+		}
+		data.Favorites = append(data.Favorites, placeID)
+		learnInto(&data.Preferences, place.Source.Category, favoriteWeight)
+		return true, nil
+	})
+}
+
+// RemoveFavorite removes a place from the favorites of a user
+func (s *ElasticStore) RemoveFavorite(user, placeID string) (*types.UserData, error) {
+	// A deleted place no longer counts
+	var categories []string
+	if place, err := s.GetPlace(placeID); err == nil {
+		categories = place.Source.Category
+	} else if err != types.ErrNotFound {
+		return nil, err
+	}
+	return s.updateUserData(user, func(data *types.UserData) (bool, error) {
+		favorites := make([]string, 0, len(data.Favorites))
+		for _, id := range data.Favorites {
+			if id != placeID {
+				favorites = append(favorites, id)
+			}
+		}
+		// The place may have been saved under the id of a duplicate
+		// merged into it since
+		if len(favorites) == len(data.Favorites) {
+			favorites = favorites[:0]
+			for _, id := range data.Favorites {
+				target, err := s.MergedInto(id)
+				if err != nil && err != types.ErrNotFound {
+					return false, err
+				}
+				if target != placeID {
+					favorites = append(favorites, id)
+				}
+			}
+		}
+		if len(favorites) == len(data.Favorites) {
+			return false, nil
+		}
+		data.Favorites = favorites
+		learnInto(&data.Preferences, categories, -favoriteWeight)
+		return true, nil
+	})
+}
+
+// PutList creates a list of a user if it has no id, and replaces the
+// name, places and sharing of their existing list otherwise
+func (s *ElasticStore) PutList(user string, list types.PlaceList) (*types.PlaceList, error) {
+	places, err := s.checkPlaces(list.Places)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	list.Places = places
+	list.UpdatedAt = now
+	// The ids are made once, so that a retried save does not take the
+	// new list for an existing one
+	created := list.ID == ""
+	if created {
+		if list.ID, err = randomID(8); err != nil {
+			return nil, err
+		}
+		// The share id is kept when sharing is turned off and on again
+		if list.ShareID, err = randomID(16); err != nil {
+			return nil, err
+		}
+		list.CreatedAt = now
+	}
+
+	_, err = s.updateUserData(user, func(data *types.UserData) (bool, error) {
+		if created {
+			data.Lists = append(data.Lists, list)
+			return true, nil
+		}
+		i := findList(data.Lists, list.ID)
+		if i < 0 {
+			return false, types.ErrListNotFound
+		}
+		list.ShareID = data.Lists[i].ShareID
+		list.CreatedAt = data.Lists[i].CreatedAt
+		data.Lists[i] = list
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// DeleteList deletes a list of a user
+func (s *ElasticStore) DeleteList(user, listID string) error {
+	_, err := s.updateUserData(user, func(data *types.UserData) (bool, error) {
+		i := findList(data.Lists, listID)
+		if i < 0 {
+			return false, types.ErrListNotFound
+		}
+		data.Lists = append(data.Lists[:i], data.Lists[i+1:]...)
+		return true, nil
+	})
+	return err
+}
+
+// GetSharedList returns a shared list by its share id, types.ErrListNotFound
+// if there is none or it is no longer shared
+func (s *ElasticStore) GetSharedList(shareID string) (*types.PlaceList, error) {
+	query := map[string]interface{}{
+		"size": 1,
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{"lists.share_id": shareID},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex(userDataIndex),
+		s.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// Nobody saved anything yet
+	if res.StatusCode == http.StatusNotFound {
+		return nil, types.ErrListNotFound
+	} else if res.IsError() {
+		return nil, fmt.Errorf("GetSharedList: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source types.UserData `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	for _, hit := range result.Hits.Hits {
+		for _, list := range hit.Source.Lists {
+			if list.ShareID == shareID && list.Shared {
+				return &list, nil
+			}
+		}
+	}
+	return nil, types.ErrListNotFound
+}
+
+// GetPlacesByID returns the places with the given ids in the same order,
+// ids of merged places give their canonical place and ids of deleted
+// places are skipped
+func (s *ElasticStore) GetPlacesByID(ids []string) ([]Place, error) {
+	byID, err := s.resolvePlaces(ids)
+	if err != nil {
+		return nil, err
+	}
+	places := make([]Place, 0, len(byID))
+	shown := make(map[string]bool, len(byID))
+	for _, id := range ids {
+		// A list shows a place once, even if it was saved under two ids
+		if place, ok := byID[id]; ok && !shown[place.ID] {
+			shown[place.ID] = true
+			places = append(places, place)
+		}
+	}
+	return places, nil
+}
+
+// Places by the ids they were asked for. Saved ids of places merged later
+// get the canonical place, so favorites and lists survive deduplication.
+func (s *ElasticStore) resolvePlaces(ids []string) (map[string]Place, error) {
+	found, err := s.fetchPlaces(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Place, len(ids))
+	for _, place := range found {
+		byID[place.ID] = place
+	}
+
+	merged := make(map[string]string)
+	targets := make([]string, 0)
+	for _, id := range ids {
+		if _, ok := byID[id]; ok || merged[id] != "" {
+			continue
+		}
+		target, err := s.MergedInto(id)
+		if err == types.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		merged[id] = target
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return byID, nil
+	}
+
+	canonical, err := s.fetchPlaces(targets)
+	if err != nil {
+		return nil, err
+	}
+	for _, place := range canonical {
+		byID[place.ID] = place
+	}
+	for id, target := range merged {
+		if place, ok := byID[target]; ok {
+			byID[id] = place
+		}
+	}
+	return byID, nil
+}
+
+// Read the places with the given ids in any order
+func (s *ElasticStore) fetchPlaces(ids []string) ([]Place, error) {
+	if len(ids) == 0 {
+		return []Place{}, nil
+	}
+	query := map[string]interface{}{
+		"size": len(ids),
+		"query": map[string]interface{}{
+			"ids": map[string]interface{}{"values": ids},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Search(
+		s.client.Search.WithContext(context.Background()),
+		s.client.Search.WithIndex("places"),
+		s.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("GetPlacesByID: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []Place `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Hits.Hits, nil
+}
+
+// Drop repeated ids, replace the ids of merged places by their canonical
+// place and check that every place exists. The error wraps
+// types.ErrNotFound and names the first unknown id.
+func (s *ElasticStore) checkPlaces(ids []string) ([]string, error) {
+	byID, err := s.resolvePlaces(ids)
+	if err != nil {
+		return nil, err
+	}
+	unique := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		place, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", types.ErrNotFound, id)
+		}
+		if !seen[place.ID] {
+			seen[place.ID] = true
+			unique = append(unique, place.ID)
+		}
+	}
+	return unique, nil
+}
+
+// Position of a list by id, -1 if there is none
+func findList(lists []types.PlaceList, id string) int {
+	for i, list := range lists {
+		if list.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Random hex id of n bytes, share ids must not be guessable
+func randomID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
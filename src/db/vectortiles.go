@@ -0,0 +1,46 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"day03es/tiles"
+)
+
+// Most places in one vector tile
+const tilePlaces = 10000
+
+// VectorTile returns a Mapbox Vector Tile with the places in a tile, built
+// by Elasticsearch. Its "hits" layer has a point per place with the
+// attributes _id, name and category.
+func (s *ElasticStore) VectorTile(t tiles.Tile) ([]byte, error) {
+	query := map[string]interface{}{
+		// Only the places, no aggregation layer
+		"grid_precision":   0,
+		"exact_bounds":     false,
+		"size":             tilePlaces,
+		"fields":           []string{"name", "category"},
+		"track_total_hits": false,
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.SearchMvt([]string{"places"}, "location", &t.X, &t.Y, &t.Z,
+		s.client.SearchMvt.WithContext(context.Background()),
+		s.client.SearchMvt.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("VectorTile %s: %s", t, res.String())
+	}
+	return io.ReadAll(res.Body)
+}
@@ -0,0 +1,213 @@
+// Package dedup finds places that are probably the same venue entered
+// more than once: close to each other and with a similar name or the
+// same phone number.
+package dedup
+
+import (
+	"math"
+	"sort"
+
+	"day03es/translit"
+	"day03es/types"
+)
+
+// Candidate is a place taking part in duplicate detection
+type Candidate struct {
+	ID       string
+	Name     string
+	Phones   []string
+	Location types.Location
+}
+
+// Options are the thresholds of duplicate detection
+type Options struct {
+	// Places further apart than this, in meters, are never duplicates
+	MaxDistance float64 `json:"max_distance_m"`
+	// Minimum name similarity in [0, 1] if the phones differ
+	MinSimilarity float64 `json:"min_similarity"`
+}
+
+// Default thresholds
+var DefaultOptions = Options{MaxDistance: 50, MinSimilarity: 0.8}
+
+// Pair is one match between two candidates
+type Pair struct {
+	A          string  `json:"a"`
+	B          string  `json:"b"`
+	Distance   float64 `json:"distance_m"`
+	Similarity float64 `json:"name_similarity"`
+	SamePhone  bool    `json:"same_phone"`
+}
+
+// Group is a set of places that should be reviewed together
+type Group struct {
+	// Suggested place to keep, the one with the lowest id
+	Canonical string   `json:"canonical"`
+	IDs       []string `json:"ids"`
+	Names     []string `json:"names"`
+	Pairs     []Pair   `json:"pairs"`
+}
+
+// Meters per degree of latitude
+const metersPerDegree = 111320.0
+
+// Find returns groups of probable duplicates. Only places in the same or
+// neighbouring grid cells are compared, so the cost stays close to linear.
+func Find(places []Candidate, opts Options) []Group {
+	if len(places) == 0 || opts.MaxDistance <= 0 {
+		return []Group{}
+	}
+
+	// Longitude degrees shrink towards the poles, size cells for the
+	// highest latitude so that neighbours are never missed
+	maxLat := 0.0
+	for _, p := range places {
+		maxLat = math.Max(maxLat, math.Abs(p.Location.Lat))
+	}
+	cellLat := opts.MaxDistance / metersPerDegree
+	cellLon := cellLat / math.Max(math.Cos(maxLat*math.Pi/180), 0.01)
+
+	type cell struct{ x, y int }
+	grid := make(map[cell][]int)
+	folded := make([]string, len(places))
+	for i, p := range places {
+		c := cell{int(math.Floor(p.Location.Lon / cellLon)), int(math.Floor(p.Location.Lat / cellLat))}
+		grid[c] = append(grid[c], i)
+		folded[i] = translit.Fold(p.Name)
+	}
+
+	uf := newUnionFind(len(places))
+	pairs := make([]Pair, 0)
+	for i, p := range places {
+		c := cell{int(math.Floor(p.Location.Lon / cellLon)), int(math.Floor(p.Location.Lat / cellLat))}
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for _, j := range grid[cell{c.x + dx, c.y + dy}] {
+					// Compare every pair once
+					if j <= i {
+						continue
+					}
+					q := places[j]
+					dist := types.Distance(p.Location, q.Location)
+					if dist > opts.MaxDistance {
+						continue
+					}
+					sim := Similarity(folded[i], folded[j])
+					samePhone := sharePhone(p.Phones, q.Phones)
+					if sim < opts.MinSimilarity && !samePhone {
+						continue
+					}
+					pairs = append(pairs, Pair{
+						A:          p.ID,
+						B:          q.ID,
+						Distance:   math.Round(dist*10) / 10,
+						Similarity: math.Round(sim*1000) / 1000,
+						SamePhone:  samePhone,
+					})
+					uf.union(i, j)
+				}
+			}
+		}
+	}
+
+	// Collect the connected places into groups
+	byRoot := make(map[int]*Group)
+	index := make(map[string]int, len(places))
+	for i, p := range places {
+		index[p.ID] = i
+	}
+	for _, pair := range pairs {
+		root := uf.find(index[pair.A])
+		g, ok := byRoot[root]
+		if !ok {
+			g = &Group{}
+			byRoot[root] = g
+		}
+		g.Pairs = append(g.Pairs, pair)
+	}
+
+	for i, p := range places {
+		if g, ok := byRoot[uf.find(i)]; ok {
+			g.IDs = append(g.IDs, p.ID)
+		}
+	}
+
+	groups := make([]Group, 0, len(byRoot))
+	for _, g := range byRoot {
+		types.SortIDs(g.IDs)
+		for _, id := range g.IDs {
+			g.Names = append(g.Names, places[index[id]].Name)
+		}
+		g.Canonical = g.IDs[0]
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return types.LessID(groups[i].Canonical, groups[j].Canonical) })
+	return groups
+}
+
+// Similarity returns a value in [0, 1] based on the edit distance
+// between two names, 1 meaning equal
+func Similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := math.Max(float64(len(ra)), float64(len(rb)))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/longest
+}
+
+// Edit distance between two strings
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Check if two lists of normalized phone numbers have one in common
+func sharePhone(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Disjoint set of place indexes
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	uf := make(unionFind, n)
+	for i := range uf {
+		uf[i] = i
+	}
+	return uf
+}
+
+func (uf unionFind) find(i int) int {
+	for uf[i] != i {
+		uf[i] = uf[uf[i]]
+		i = uf[i]
+	}
+	return i
+}
+
+func (uf unionFind) union(i, j int) {
+	uf[uf.find(i)] = uf.find(j)
+}
@@ -0,0 +1,95 @@
+// Package diversity picks a varied subset of ranked results with maximal
+// marginal relevance: each next item is the one that is most relevant
+// and least similar to the items already picked.
+package diversity
+
+import "strings"
+
+// Item is a ranked result, the first item is the most relevant
+type Item struct {
+	// Items with the same chain are copies of one venue, e.g. branches
+	Chain      string
+	Categories []string
+}
+
+// Options of a selection
+type Options struct {
+	// Trade-off between relevance (1) and diversity (0)
+	Lambda float64
+	// Pick at most one item per chain
+	Chains bool
+	// Penalize items sharing categories with the picked ones
+	Categories bool
+}
+
+// Select returns the indexes of up to k items in the order they are
+// picked. Relevance comes from the order of the items, so the result is
+// the same for the same input. Ties go to the more relevant item.
+func Select(items []Item, k int, opts Options) []int {
+	picked := make([]int, 0, k)
+	used := make([]bool, len(items))
+	chains := make(map[string]bool)
+
+	for len(picked) < k {
+		best, bestScore := -1, 0.0
+		for i, item := range items {
+			if used[i] || (opts.Chains && item.Chain != "" && chains[item.Chain]) {
+				continue
+			}
+			// Linear relevance from 1 for the first item down to 0
+			relevance := 1.0
+			if len(items) > 1 {
+				relevance = 1 - float64(i)/float64(len(items)-1)
+			}
+			redundancy := 0.0
+			for _, j := range picked {
+				redundancy = max(redundancy, similarity(item, items[j], opts))
+			}
+			score := opts.Lambda*relevance - (1-opts.Lambda)*redundancy
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		picked = append(picked, best)
+		used[best] = true
+		chains[items[best].Chain] = true
+	}
+	return picked
+}
+
+// Similarity of two items in [0, 1]
+func similarity(a, b Item, opts Options) float64 {
+	if a.Chain != "" && a.Chain == b.Chain {
+		return 1
+	}
+	if !opts.Categories {
+		return 0
+	}
+	return jaccard(a.Categories, b.Categories)
+}
+
+// Share of the categories the two lists have in common
+func jaccard(a, b []string) float64 {
+	setA, setB := toSet(a), toSet(b)
+	if len(setA) == 0 || len(setB) == 0 {
+		return 0
+	}
+	common := 0
+	for c := range setB {
+		if setA[c] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(setA)+len(setB)-common)
+}
+
+func toSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, c := range list {
+		set[strings.ToLower(c)] = true
+	}
+	return set
+}
@@ -0,0 +1,51 @@
+package diversity
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Branches of one chain among places of mixed categories, most relevant first
+var fixture = []Item{
+	{Chain: "starbucks", Categories: []string{"cafe"}},
+	{Chain: "starbucks", Categories: []string{"cafe"}},
+	{Chain: "coffee house", Categories: []string{"Cafe"}},
+	{Chain: "pizza hut", Categories: []string{"restaurant", "pizza"}},
+	{Chain: "starbucks", Categories: []string{"cafe"}},
+	{Chain: "book shop", Categories: []string{"shop"}},
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		name string
+		k    int
+		opts Options
+		want []int
+	}{
+		{"relevance only", 4, Options{Lambda: 1}, []int{0, 1, 2, 3}},
+		{"relevance only, categories ignored", 4, Options{Lambda: 1, Categories: true}, []int{0, 1, 2, 3}},
+		{"relevance, one per chain", 4, Options{Lambda: 1, Chains: true}, []int{0, 2, 3, 5}},
+		{"balanced", 4, Options{Lambda: 0.5}, []int{0, 2, 3, 5}},
+		{"balanced, categories", 4, Options{Lambda: 0.5, Categories: true}, []int{0, 3, 5, 1}},
+		{"balanced, one per chain", 4, Options{Lambda: 0.5, Chains: true}, []int{0, 2, 3, 5}},
+		{"balanced, chains and categories", 4, Options{Lambda: 0.5, Chains: true, Categories: true}, []int{0, 3, 5, 2}},
+		{"diversity only", 4, Options{Lambda: 0}, []int{0, 2, 3, 5}},
+		{"diversity only, categories", 4, Options{Lambda: 0, Categories: true}, []int{0, 3, 5, 1}},
+		{"diversity only, chains and categories", 4, Options{Lambda: 0, Chains: true, Categories: true}, []int{0, 3, 5, 2}},
+		{"fewer chains than asked", 10, Options{Lambda: 1, Chains: true}, []int{0, 2, 3, 5}},
+		{"nothing asked", 0, Options{Lambda: 0.5}, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Select(fixture, tt.k, tt.opts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			// The same input gives the same selection
+			if again := Select(fixture, tt.k, tt.opts); !reflect.DeepEqual(again, got) {
+				t.Errorf("second run got %v, first %v", again, got)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ module day03es
 go 1.21.1
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/elastic/go-elasticsearch/v8 v8.5.0
 	github.com/golang-jwt/jwt v3.2.2+incompatible
 )
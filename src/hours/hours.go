@@ -0,0 +1,288 @@
+// Package hours parses opening hours in the OpenStreetMap opening_hours
+// syntax, e.g. "Mo-Fr 09:00-21:00; Sa,Su 10:00-18:00; PH off", and tells
+// whether a place is open at a given time.
+//
+// Only weekly schedules are supported: weekday selectors, time spans
+// (also past midnight), "24/7" and "off". Rules for public and school
+// holidays are ignored since the holiday calendar is not known.
+package hours
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Minutes in a day and in a week
+const (
+	DayMinutes  = 24 * 60
+	WeekMinutes = 7 * DayMinutes
+)
+
+// DefaultLocation is the time zone schedules are evaluated in. Moscow has
+// had no daylight saving time since 2014, so a fixed zone is used when
+// the time zone database is not available.
+var DefaultLocation = loadLocation("Europe/Moscow", 3*60*60)
+
+func loadLocation(name string, offset int) *time.Location {
+	if loc, err := time.LoadLocation(name); err == nil {
+		return loc
+	}
+	return time.FixedZone(name, offset)
+}
+
+// Range is an open interval of a week in minutes since Monday 00:00,
+// End is exclusive
+type Range struct {
+	Start int `json:"gte"`
+	End   int `json:"lt"`
+}
+
+// Schedule is a parsed opening_hours value
+type Schedule struct {
+	// Sorted, non overlapping ranges within one week
+	ranges []Range
+	loc    *time.Location
+}
+
+var weekdays = map[string]int{"Mo": 0, "Tu": 1, "We": 2, "Th": 3, "Fr": 4, "Sa": 5, "Su": 6}
+
+// Parse reads an opening_hours value, evaluated in DefaultLocation
+func Parse(s string) (*Schedule, error) {
+	return ParseIn(s, DefaultLocation)
+}
+
+// ParseIn reads an opening_hours value evaluated in the given time zone
+func ParseIn(s string, loc *time.Location) (*Schedule, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("empty opening hours")
+	}
+
+	// Open spans of every weekday, a span may end after midnight
+	var days [7][]Range
+	for _, rule := range strings.Split(s, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if rule == "24/7" {
+			for d := range days {
+				days[d] = []Range{{0, DayMinutes}}
+			}
+			continue
+		}
+
+		// Glue lists like "Mo, We" or "10:00-14:00, 15:00-19:00" together
+		fields := strings.Fields(strings.ReplaceAll(rule, ", ", ","))
+		selected := []int{0, 1, 2, 3, 4, 5, 6}
+		if len(fields) > 0 && !startsWithDigit(fields[0]) && !isState(fields[0]) {
+			// Holidays are not known, their rules never apply
+			if isHoliday(fields[0]) {
+				continue
+			}
+			var err error
+			if selected, err = parseDays(fields[0]); err != nil {
+				return nil, fmt.Errorf("rule '%s': %w", rule, err)
+			}
+			fields = fields[1:]
+		}
+
+		// Days without times are open all day
+		spans := []Range{{0, DayMinutes}}
+		switch {
+		case len(fields) == 0:
+		case len(fields) == 1 && isState(fields[0]):
+			if state := strings.ToLower(fields[0]); state == "off" || state == "closed" {
+				spans = nil
+			}
+		case len(fields) == 1:
+			var err error
+			if spans, err = parseSpans(fields[0]); err != nil {
+				return nil, fmt.Errorf("rule '%s': %w", rule, err)
+			}
+		default:
+			return nil, fmt.Errorf("rule '%s': unsupported syntax", rule)
+		}
+
+		// A later rule replaces the hours of the days it selects
+		for _, d := range selected {
+			days[d] = spans
+		}
+	}
+
+	ranges := make([]Range, 0)
+	for d, spans := range days {
+		for _, span := range spans {
+			start, end := d*DayMinutes+span.Start, d*DayMinutes+span.End
+			// Sunday night spills into Monday morning
+			if end > WeekMinutes {
+				ranges = append(ranges, Range{0, end - WeekMinutes})
+				end = WeekMinutes
+			}
+			ranges = append(ranges, Range{start, end})
+		}
+	}
+	return &Schedule{ranges: merge(ranges), loc: loc}, nil
+}
+
+func startsWithDigit(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+func isState(s string) bool {
+	switch strings.ToLower(s) {
+	case "off", "closed", "open":
+		return true
+	}
+	return false
+}
+
+func isHoliday(s string) bool {
+	return strings.HasPrefix(s, "PH") || strings.HasPrefix(s, "SH")
+}
+
+// Parse a weekday selector like "Mo-Fr" or "Mo,We,Fr-Su"
+func parseDays(s string) ([]int, error) {
+	selected := make([]int, 0, 7)
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday '%s'", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return nil, fmt.Errorf("unknown weekday '%s'", to)
+			}
+		}
+		// Ranges like Fr-Mo wrap around the week
+		for d := first; ; d = (d + 1) % 7 {
+			selected = append(selected, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return selected, nil
+}
+
+// Parse time spans like "10:00-14:00,15:00-02:00"
+func parseSpans(s string) ([]Range, error) {
+	spans := make([]Range, 0)
+	for _, part := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid time span '%s'", part)
+		}
+		start, err := parseTime(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTime(to)
+		if err != nil {
+			return nil, err
+		}
+		if start >= DayMinutes {
+			return nil, fmt.Errorf("invalid time span '%s'", part)
+		}
+		if end <= start {
+			end += DayMinutes
+		}
+		spans = append(spans, Range{start, end})
+	}
+	return spans, nil
+}
+
+// Parse "HH:MM" into minutes since midnight, "24:00" is allowed
+func parseTime(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if !ok || errH != nil || errM != nil || len(mm) != 2 || h < 0 || m < 0 || m > 59 || h*60+m > DayMinutes {
+		return 0, fmt.Errorf("invalid time '%s'", s)
+	}
+	return h*60 + m, nil
+}
+
+// Sort ranges and join the overlapping or touching ones
+func merge(ranges []Range) []Range {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	merged := make([]Range, 0, len(ranges))
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Ranges returns the open ranges of a week in minutes since Monday 00:00
+func (s *Schedule) Ranges() []Range {
+	return s.ranges
+}
+
+// WeekMinute returns the minutes since Monday 00:00 of a time in a time zone
+func WeekMinute(t time.Time, loc *time.Location) int {
+	t = t.In(loc)
+	day := (int(t.Weekday()) + 6) % 7
+	return day*DayMinutes + t.Hour()*60 + t.Minute()
+}
+
+// Start of the week of a time, Monday 00:00
+func weekStart(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	day := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-day, 0, 0, 0, 0, loc)
+}
+
+// OpenAt reports whether the place is open at a time
+func (s *Schedule) OpenAt(t time.Time) bool {
+	m := WeekMinute(t, s.loc)
+	for _, r := range s.ranges {
+		if r.Start <= m && m < r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Open ranges of three consecutive weeks, so that changes up to a week
+// ahead are found across the end of the week
+func (s *Schedule) unrolled() []Range {
+	ranges := make([]Range, 0, 3*len(s.ranges))
+	for week := 0; week < 3; week++ {
+		for _, r := range s.ranges {
+			ranges = append(ranges, Range{r.Start + week*WeekMinutes, r.End + week*WeekMinutes})
+		}
+	}
+	return merge(ranges)
+}
+
+// NextOpen returns when the place opens next after t, false if it
+// never opens or never closes
+func (s *Schedule) NextOpen(t time.Time) (time.Time, bool) {
+	m := WeekMinute(t, s.loc)
+	for _, r := range s.unrolled() {
+		if r.Start > m {
+			return weekStart(t, s.loc).Add(time.Duration(r.Start) * time.Minute), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// NextClose returns when the place closes next after t, false if it
+// never opens or never closes
+func (s *Schedule) NextClose(t time.Time) (time.Time, bool) {
+	m := WeekMinute(t, s.loc)
+	for _, r := range s.unrolled() {
+		if r.End > m && r.End < 3*WeekMinutes {
+			return weekStart(t, s.loc).Add(time.Duration(r.End) * time.Minute), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,98 @@
+package hours
+
+import (
+	"testing"
+	"time"
+)
+
+// Monday 1 January 2024 is the first day of the test week
+var week = map[string]int{"Mo": 1, "Tu": 2, "We": 3, "Th": 4, "Fr": 5, "Sa": 6, "Su": 7, "next Mo": 8}
+
+// Time of a weekday of the test week in UTC
+func at(day string, hour, minute int) time.Time {
+	return time.Date(2024, time.January, week[day], hour, minute, 0, 0, time.UTC)
+}
+
+func TestOpenAt(t *testing.T) {
+	tests := []struct {
+		hours string
+		at    time.Time
+		want  bool
+	}{
+		{"Mo-Fr 09:00-18:00", at("Mo", 10, 0), true},
+		{"Mo-Fr 09:00-18:00", at("Mo", 8, 59), false},
+		{"Mo-Fr 09:00-18:00", at("Fr", 17, 59), true},
+		{"Mo-Fr 09:00-18:00", at("Fr", 18, 0), false},
+		{"Mo-Fr 09:00-18:00", at("Sa", 10, 0), false},
+		{"24/7", at("Su", 3, 0), true},
+		{"Fr-Sa 18:00-02:00", at("Fr", 17, 0), false},
+		{"Fr-Sa 18:00-02:00", at("Sa", 1, 0), true},
+		{"Fr-Sa 18:00-02:00", at("Su", 1, 0), true},
+		{"Fr-Sa 18:00-02:00", at("Su", 2, 0), false},
+		{"Fr-Sa 18:00-02:00", at("Mo", 1, 0), false},
+		{"Su 22:00-03:00", at("Su", 23, 0), true},
+		{"Su 22:00-03:00", at("Mo", 2, 59), true},
+		{"Su 22:00-03:00", at("Mo", 3, 0), false},
+		{"Su 22:00-03:00", at("Sa", 23, 0), false},
+		{"Mo-Su 10:00-20:00; We off", at("We", 12, 0), false},
+		{"Mo-Su 10:00-20:00; We off", at("Th", 12, 0), true},
+		{"Mo-Fr 09:00-18:00; PH off", at("Mo", 10, 0), true},
+		{"PH 10:00-12:00", at("Mo", 11, 0), false},
+		{"off", at("Tu", 12, 0), false},
+		{"Mo-Fr 09:00-12:00, 12:00-18:00", at("Mo", 12, 0), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hours+" "+tt.at.Format("Mon 15:04"), func(t *testing.T) {
+			s, err := ParseIn(tt.hours, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.OpenAt(tt.at); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextOpenClose(t *testing.T) {
+	never := time.Time{}
+	tests := []struct {
+		hours     string
+		at        time.Time
+		nextOpen  time.Time
+		nextClose time.Time
+	}{
+		{"Mo-Fr 09:00-18:00", at("Mo", 10, 0), at("Tu", 9, 0), at("Mo", 18, 0)},
+		{"Mo-Fr 09:00-18:00", at("Fr", 19, 0), at("next Mo", 9, 0), at("next Mo", 18, 0)},
+		// Touching spans are one opening
+		{"Mo-Fr 09:00-12:00, 12:00-18:00", at("Mo", 10, 0), at("Tu", 9, 0), at("Mo", 18, 0)},
+		{"Fr-Sa 18:00-02:00", at("Sa", 1, 0), at("Sa", 18, 0), at("Sa", 2, 0)},
+		{"Su 22:00-03:00", at("Su", 23, 0), at("Su", 22, 0).AddDate(0, 0, 7), at("next Mo", 3, 0)},
+		{"24/7", at("We", 12, 0), never, never},
+		{"off", at("We", 12, 0), never, never},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hours+" "+tt.at.Format("Mon 15:04"), func(t *testing.T) {
+			s, err := ParseIn(tt.hours, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := s.NextOpen(tt.at); !got.Equal(tt.nextOpen) || ok == tt.nextOpen.IsZero() {
+				t.Errorf("NextOpen: got %v (%v), want %v", got, ok, tt.nextOpen)
+			}
+			if got, ok := s.NextClose(tt.at); !got.Equal(tt.nextClose) || ok == tt.nextClose.IsZero() {
+				t.Errorf("NextClose: got %v (%v), want %v", got, ok, tt.nextClose)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, value := range []string{"", "Xx 10:00-12:00", "Mo 25:00-26:00", "Mo 10-12", "Mo 10:00-12:00 extra"} {
+		if _, err := ParseIn(value, time.UTC); err == nil {
+			t.Errorf("%q: no error", value)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Columns maps the fields of a place to column names of the header row
+type Columns struct {
+	ID      string
+	Name    string
+	Address string
+	Phone   string
+	Lat     string
+	Lon     string
+
+	OpeningHours string
+}
+
+// Column names of the original dataset, the id column has no name
+var DefaultColumns = Columns{
+	ID:      "",
+	Name:    "Name",
+	Address: "Address",
+	Phone:   "Phone",
+	Lat:     "Latitude",
+	Lon:     "Longitude",
+
+	// Optional, the dataset itself has no opening hours
+	OpeningHours: "OpeningHours",
+}
+
+// ParseColumns reads overrides like "name=Title,lat=Y,lon=X" on top of
+// the default column names
+func ParseColumns(spec string) (Columns, error) {
+	c := DefaultColumns
+	if strings.TrimSpace(spec) == "" {
+		return c, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		field, column, ok := strings.Cut(pair, "=")
+		if !ok {
+			return c, fmt.Errorf("invalid column mapping '%s'", pair)
+		}
+		column = strings.TrimSpace(column)
+		switch strings.TrimSpace(strings.ToLower(field)) {
+		case "id":
+			c.ID = column
+		case "name":
+			c.Name = column
+		case "address":
+			c.Address = column
+		case "phone":
+			c.Phone = column
+		case "lat":
+			c.Lat = column
+		case "lon":
+			c.Lon = column
+		case "opening_hours":
+			c.OpeningHours = column
+		default:
+			return c, fmt.Errorf("unknown field '%s' in column mapping", field)
+		}
+	}
+	return c, nil
+}
+
+// Delimited reads CSV or TSV files with a header row. If Comma is 0
+// the delimiter is detected from the header.
+type Delimited struct {
+	Comma   rune
+	Columns Columns
+}
+
+func (d *Delimited) Format() string {
+	if d.Comma == '\t' {
+		return "tsv"
+	}
+	return "csv"
+}
+
+func (d *Delimited) Read(r io.Reader) ([]Row, error) {
+	br := bufio.NewReader(r)
+	if d.Comma == 0 {
+		// The original dataset is tab separated despite its .csv name
+		first, err := br.Peek(br.Size())
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		line, _, _ := strings.Cut(string(first), "\n")
+		d.Comma = ','
+		if strings.Count(line, "\t") > strings.Count(line, ",") {
+			d.Comma = '\t'
+		}
+	}
+
+	reader := csv.NewReader(br)
+	reader.Comma = d.Comma
+	reader.FieldsPerRecord = -1 // column count is checked per row
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	// Find the position of every mapped column
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.TrimSpace(name)] = i
+	}
+	position := func(name string, required bool) (int, error) {
+		i, ok := index[name]
+		if !ok && required {
+			return -1, fmt.Errorf("column '%s' not found in header", name)
+		} else if !ok {
+			return -1, nil
+		}
+		return i, nil
+	}
+
+	var cols [7]int
+	for i, c := range []struct {
+		name     string
+		required bool
+	}{
+		{d.Columns.ID, false},
+		{d.Columns.Name, true},
+		{d.Columns.Address, false},
+		{d.Columns.Phone, false},
+		{d.Columns.Lat, true},
+		{d.Columns.Lon, true},
+		{d.Columns.OpeningHours, false},
+	} {
+		if cols[i], err = position(c.name, c.required); err != nil {
+			return nil, err
+		}
+	}
+
+	rows := make([]Row, 0)
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, Row{Line: parseErr.StartLine, Problems: []string{parseErr.Err.Error()}})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		row := Row{Line: line, Ref: "line " + strconv.Itoa(line)}
+		if len(record) != len(header) {
+			row.Problems = append(row.Problems, fmt.Sprintf("expected %d columns, got %d", len(header), len(record)))
+		}
+
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		row.ID = field(cols[0])
+		if cols[0] < 0 {
+			// No id column, number the rows
+			row.ID = generatedID(d.Format(), "row", int64(n))
+		}
+		row.Name = field(cols[1])
+		row.Address = field(cols[2])
+		row.Phone = field(cols[3])
+		row.Lat = field(cols[4])
+		row.Lon = field(cols[5])
+		row.OpeningHours = field(cols[6])
+
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
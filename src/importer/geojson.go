@@ -0,0 +1,91 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// GeoJSON reads the Point features of a FeatureCollection
+type GeoJSON struct{}
+
+func (GeoJSON) Format() string { return "geojson" }
+
+func (GeoJSON) Read(r io.Reader) ([]Row, error) {
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			ID       interface{} `json:"id"`
+			Geometry *struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return nil, err
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("expected a FeatureCollection, got '%s'", collection.Type)
+	}
+
+	rows := make([]Row, 0, len(collection.Features))
+	for i, f := range collection.Features {
+		n := i + 1
+		row := Row{
+			Line:    n,
+			Ref:     "feature " + strconv.Itoa(n),
+			ID:      idString(f.ID),
+			Name:    stringProp(f.Properties, "name"),
+			Address: stringProp(f.Properties, "address"),
+			Phone:   stringProp(f.Properties, "phone"),
+
+			OpeningHours: stringProp(f.Properties, "opening_hours"),
+		}
+		if row.ID == "" {
+			row.ID = idString(f.Properties["id"])
+		}
+		if row.ID == "" {
+			row.ID = generatedID("geojson", "feature", int64(n))
+		}
+
+		// GeoJSON positions are [longitude, latitude]
+		var coords []float64
+		switch {
+		case f.Geometry == nil:
+			row.Problems = append(row.Problems, "missing geometry")
+		case f.Geometry.Type != "Point":
+			row.Problems = append(row.Problems, fmt.Sprintf("unsupported geometry '%s'", f.Geometry.Type))
+		case json.Unmarshal(f.Geometry.Coordinates, &coords) != nil || len(coords) < 2:
+			row.Problems = append(row.Problems, "invalid coordinates")
+		default:
+			row.Lon, row.Lat = formatCoord(coords[0]), formatCoord(coords[1])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Convert a JSON id (string or number) to a string
+func idString(v interface{}) string {
+	switch id := v.(type) {
+	case string:
+		return id
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64)
+	}
+	return ""
+}
+
+// Read a string property, numbers are converted
+func stringProp(props map[string]interface{}, key string) string {
+	switch v := props[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+// Package importer reads places from the supported data sources into
+// rows that can be validated and indexed.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Row is one place read from a data source, before validation
+type Row struct {
+	// Line or record number in the source, used in reports
+	Line    int
+	ID      string
+	Name    string
+	Address string
+	Phone   string
+	Lat     string
+	Lon     string
+
+	// In the OSM opening_hours syntax, may be empty
+	OpeningHours string
+
+	// Reference of the record in its source, e.g. "node/123"
+	Ref string
+
+	// Reasons why the record could not be read properly
+	Problems []string
+}
+
+// Importer reads every place record of a source
+type Importer interface {
+	// Format is recorded with every document as its provenance.
+	// It may only be known after Read.
+	Format() string
+
+	// Read returns every record of the source. Records that can not be
+	// read have Problems set, only I/O errors stop the reading.
+	Read(r io.Reader) ([]Row, error)
+}
+
+// Supported formats and the file extensions they are detected by
+var extensions = map[string]string{
+	".tsv":     "tsv",
+	".csv":     "csv",
+	".geojson": "geojson",
+	".json":    "geojson",
+	".ndjson":  "ndjson",
+	".jsonl":   "ndjson",
+	".osm":     "osm",
+	".pbf":     "pbf",
+}
+
+// ForFile returns the importer for a format. If format is empty it is
+// detected from the file extension. Columns only apply to tsv and csv.
+func ForFile(path, format string, columns Columns) (Importer, error) {
+	if format == "" {
+		format = extensions[strings.ToLower(filepath.Ext(path))]
+
+		// Sniff the delimiter, .csv files are often tab separated
+		if format == "tsv" || format == "csv" {
+			return &Delimited{Columns: columns}, nil
+		}
+	}
+
+	switch format {
+	case "tsv":
+		return &Delimited{Comma: '\t', Columns: columns}, nil
+	case "csv":
+		return &Delimited{Comma: ',', Columns: columns}, nil
+	case "geojson":
+		return GeoJSON{}, nil
+	case "ndjson":
+		return NDJSON{}, nil
+	case "osm":
+		return OSMXML{}, nil
+	case "pbf":
+		return OSMPBF{}, nil
+	}
+	return nil, fmt.Errorf("unknown import format for '%s'", path)
+}
+
+// Id of a record whose source has none, prefixed with the format and the
+// kind of record so that it never collides with the ids of the dataset or
+// of another format, e.g. "csv-row-12"
+func generatedID(format, kind string, n int64) string {
+	return format + "-" + kind + "-" + strconv.FormatInt(n, 10)
+}
+
+// Format a coordinate read as a number
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,61 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratedIDs(t *testing.T) {
+	tests := []struct {
+		name  string
+		imp   Importer
+		input string
+		want  []string
+	}{
+		{
+			name:  "csv without id column",
+			imp:   &Delimited{Comma: ',', Columns: DefaultColumns},
+			input: "Name,Latitude,Longitude\nA,55.7,37.6\nB,55.8,37.7\n",
+			want:  []string{"csv-row-1", "csv-row-2"},
+		},
+		{
+			name:  "tsv with the dataset id column",
+			imp:   &Delimited{Comma: '\t', Columns: DefaultColumns},
+			input: "\tName\tLatitude\tLongitude\n7\tA\t55.7\t37.6\n",
+			want:  []string{"7"},
+		},
+		{
+			name: "geojson ids, properties ids and numbering",
+			imp:  GeoJSON{},
+			input: `{"type": "FeatureCollection", "features": [
+				{"type": "Feature", "id": "way-1", "geometry": {"type": "Point", "coordinates": [37.6, 55.7]}, "properties": {}},
+				{"type": "Feature", "geometry": {"type": "Point", "coordinates": [37.6, 55.7]}, "properties": {"id": 12}},
+				{"type": "Feature", "geometry": {"type": "Point", "coordinates": [37.6, 55.7]}, "properties": {}}
+			]}`,
+			want: []string{"way-1", "12", "geojson-feature-3"},
+		},
+		{
+			name:  "osm nodes",
+			imp:   OSMXML{},
+			input: `<osm><node id="42" lat="55.7" lon="37.6"><tag k="amenity" v="cafe"/></node></osm>`,
+			want:  []string{"osm-node-42"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := tt.imp.Read(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(tt.want) {
+				t.Fatalf("got %d rows, want %d", len(rows), len(tt.want))
+			}
+			for i, row := range rows {
+				if row.ID != tt.want[i] {
+					t.Errorf("row %d: got id %q, want %q", i, row.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Longest line accepted in a JSON Lines file
+const maxLineSize = 1024 * 1024
+
+// NDJSON reads one JSON object per line with the fields id, name,
+// address, phone and either lat/lon or location.lat/location.lon
+type NDJSON struct{}
+
+func (NDJSON) Format() string { return "ndjson" }
+
+func (NDJSON) Read(r io.Reader) ([]Row, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	rows := make([]Row, 0)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		row := Row{Line: line, Ref: "line " + strconv.Itoa(line)}
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &obj); err != nil {
+			row.Problems = append(row.Problems, "invalid JSON: "+err.Error())
+			rows = append(rows, row)
+			continue
+		}
+
+		row.ID = idString(obj["id"])
+		row.Name = stringProp(obj, "name")
+		row.Address = stringProp(obj, "address")
+		row.Phone = stringProp(obj, "phone")
+		row.OpeningHours = stringProp(obj, "opening_hours")
+		row.Lat = stringProp(obj, "lat")
+		row.Lon = stringProp(obj, "lon")
+		if loc, ok := obj["location"].(map[string]interface{}); ok {
+			row.Lat = stringProp(loc, "lat")
+			row.Lon = stringProp(loc, "lon")
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
@@ -0,0 +1,132 @@
+package importer
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Amenity values of the OpenStreetMap nodes that are imported
+var osmAmenities = map[string]bool{
+	"restaurant": true,
+	"cafe":       true,
+	"bar":        true,
+}
+
+// OSMXML reads restaurant, cafe and bar nodes from an .osm XML extract
+type OSMXML struct{}
+
+func (OSMXML) Format() string { return "osm" }
+
+func (OSMXML) Read(r io.Reader) ([]Row, error) {
+	decoder := xml.NewDecoder(r)
+	rows := make([]Row, 0)
+
+	// Node being read, its tags come as child elements
+	var (
+		inNode   bool
+		id       int64
+		lat, lon float64
+		tags     map[string]string
+		badNode  bool
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch el := token.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "node":
+				inNode, badNode = true, false
+				tags = make(map[string]string)
+				id, lat, lon = 0, 0, 0
+				for _, attr := range el.Attr {
+					var perr error
+					switch attr.Name.Local {
+					case "id":
+						id, perr = strconv.ParseInt(attr.Value, 10, 64)
+					case "lat":
+						lat, perr = strconv.ParseFloat(attr.Value, 64)
+					case "lon":
+						lon, perr = strconv.ParseFloat(attr.Value, 64)
+					}
+					if perr != nil {
+						badNode = true
+					}
+				}
+			case "tag":
+				if inNode {
+					var k, v string
+					for _, attr := range el.Attr {
+						switch attr.Name.Local {
+						case "k":
+							k = attr.Value
+						case "v":
+							v = attr.Value
+						}
+					}
+					tags[k] = v
+				}
+			}
+		case xml.EndElement:
+			if el.Name.Local == "node" && inNode {
+				inNode = false
+				if row, ok := osmRow(id, lat, lon, tags); ok {
+					if badNode {
+						row.Problems = append(row.Problems, "invalid node attributes")
+					}
+					row.Line = len(rows) + 1
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+	return rows, nil
+}
+
+// Build a row from an OSM node, false if it is not an imported amenity
+func osmRow(id int64, lat, lon float64, tags map[string]string) (Row, bool) {
+	if !osmAmenities[tags["amenity"]] {
+		return Row{}, false
+	}
+
+	phone := tags["phone"]
+	if phone == "" {
+		phone = tags["contact:phone"]
+	}
+
+	// Node ids are only unique within OpenStreetMap
+	return Row{
+		ID:      generatedID("osm", "node", id),
+		Ref:     "node/" + strconv.FormatInt(id, 10),
+		Name:    tags["name"],
+		Address: osmAddress(tags),
+		Phone:   phone,
+		Lat:     formatCoord(lat),
+		Lon:     formatCoord(lon),
+
+		OpeningHours: tags["opening_hours"],
+	}, true
+}
+
+// Join the addr:* tags into an address line like the dataset uses
+func osmAddress(tags map[string]string) string {
+	parts := make([]string, 0, 3)
+	if city := tags["addr:city"]; city != "" {
+		parts = append(parts, "gorod "+city)
+	}
+	if street := tags["addr:street"]; street != "" {
+		parts = append(parts, street)
+	}
+	if house := tags["addr:housenumber"]; house != "" {
+		parts = append(parts, "dom "+house)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,470 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Size limits from the OSM PBF specification
+const (
+	maxBlobHeaderSize = 64 * 1024
+	maxBlobSize       = 32 * 1024 * 1024
+)
+
+// OSMPBF reads restaurant, cafe and bar nodes from an .osm.pbf extract.
+// Only the raw and zlib blob compressions are supported.
+type OSMPBF struct{}
+
+func (OSMPBF) Format() string { return "pbf" }
+
+func (OSMPBF) Read(r io.Reader) ([]Row, error) {
+	br := bufio.NewReader(r)
+	rows := make([]Row, 0)
+
+	for {
+		// Every blob is preceded by the size of its header
+		var size uint32
+		if err := binary.Read(br, binary.BigEndian, &size); err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if size > maxBlobHeaderSize {
+			return nil, fmt.Errorf("blob header too large: %d bytes", size)
+		}
+
+		header := make([]byte, size)
+		if _, err := io.ReadFull(br, header); err != nil {
+			return nil, err
+		}
+		blobType, dataSize, err := parseBlobHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if dataSize > maxBlobSize {
+			return nil, fmt.Errorf("blob too large: %d bytes", dataSize)
+		}
+
+		blob := make([]byte, dataSize)
+		if _, err := io.ReadFull(br, blob); err != nil {
+			return nil, err
+		}
+		if blobType != "OSMData" {
+			continue
+		}
+
+		data, err := blobData(blob)
+		if err != nil {
+			return nil, err
+		}
+		if err := readPrimitiveBlock(data, func(id int64, lat, lon float64, tags map[string]string) {
+			if row, ok := osmRow(id, lat, lon, tags); ok {
+				row.Line = len(rows) + 1
+				rows = append(rows, row)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Read the type and data size of a BlobHeader message
+func parseBlobHeader(b []byte) (string, int, error) {
+	var blobType string
+	var dataSize int
+	p := protoBuf(b)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return "", 0, err
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			v, err := p.bytes()
+			if err != nil {
+				return "", 0, err
+			}
+			blobType = string(v)
+		case field == 3 && wire == wireVarint:
+			v, err := p.varint()
+			if err != nil {
+				return "", 0, err
+			}
+			// Checked before the conversion, a huge value would turn negative
+			if v > maxBlobSize {
+				return "", 0, fmt.Errorf("blob too large: %d bytes", v)
+			}
+			dataSize = int(v)
+		default:
+			if err := p.skip(wire); err != nil {
+				return "", 0, err
+			}
+		}
+	}
+	return blobType, dataSize, nil
+}
+
+// Return the uncompressed content of a Blob message
+func blobData(b []byte) ([]byte, error) {
+	p := protoBuf(b)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if wire != wireBytes {
+			if err := p.skip(wire); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		v, err := p.bytes()
+		if err != nil {
+			return nil, err
+		}
+		switch field {
+		case 1: // raw
+			return v, nil
+		case 3: // zlib_data
+			zr, err := zlib.NewReader(bytes.NewReader(v))
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			return io.ReadAll(io.LimitReader(zr, maxBlobSize))
+		default:
+			return nil, fmt.Errorf("unsupported blob compression (field %d)", field)
+		}
+	}
+	return nil, errors.New("empty blob")
+}
+
+// Call fn for every node of a PrimitiveBlock
+func readPrimitiveBlock(b []byte, fn func(id int64, lat, lon float64, tags map[string]string)) error {
+	var (
+		table     [][]byte
+		groups    [][]byte
+		granular  int64 = 100
+		latOffset int64
+		lonOffset int64
+	)
+
+	// Groups are decoded once the string table and offsets are known
+	p := protoBuf(b)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			v, err := p.bytes()
+			if err != nil {
+				return err
+			}
+			if table, err = readStringTable(v); err != nil {
+				return err
+			}
+		case field == 2 && wire == wireBytes:
+			v, err := p.bytes()
+			if err != nil {
+				return err
+			}
+			groups = append(groups, v)
+		case (field == 17 || field == 19 || field == 20) && wire == wireVarint:
+			v, err := p.varint()
+			if err != nil {
+				return err
+			}
+			switch field {
+			case 17:
+				granular = int64(v)
+			case 19:
+				latOffset = int64(v)
+			case 20:
+				lonOffset = int64(v)
+			}
+		default:
+			if err := p.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+
+	str := func(i uint64) string {
+		if i < uint64(len(table)) {
+			return string(table[i])
+		}
+		return ""
+	}
+	// OSM stores 7 decimals, more would only be float noise
+	coord := func(offset, v int64) float64 {
+		return math.Round(1e-2*float64(offset+granular*v)) / 1e7
+	}
+
+	for _, g := range groups {
+		p := protoBuf(g)
+		for !p.done() {
+			field, wire, err := p.key()
+			if err != nil {
+				return err
+			}
+			if wire != wireBytes || (field != 1 && field != 2) {
+				if err := p.skip(wire); err != nil {
+					return err
+				}
+				continue
+			}
+			v, err := p.bytes()
+			if err != nil {
+				return err
+			}
+
+			if field == 1 {
+				id, lat, lon, keys, vals, err := readNode(v)
+				if err != nil {
+					return err
+				}
+				tags := make(map[string]string, len(keys))
+				for i := range keys {
+					if i < len(vals) {
+						tags[str(keys[i])] = str(vals[i])
+					}
+				}
+				fn(id, coord(latOffset, lat), coord(lonOffset, lon), tags)
+				continue
+			}
+
+			if err := readDenseNodes(v, func(id, lat, lon int64, kv []uint64) {
+				tags := make(map[string]string, len(kv)/2)
+				for i := 0; i+1 < len(kv); i += 2 {
+					tags[str(kv[i])] = str(kv[i+1])
+				}
+				fn(id, coord(latOffset, lat), coord(lonOffset, lon), tags)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Read the strings of a StringTable message
+func readStringTable(b []byte) ([][]byte, error) {
+	table := make([][]byte, 0)
+	p := protoBuf(b)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if field != 1 || wire != wireBytes {
+			if err := p.skip(wire); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		v, err := p.bytes()
+		if err != nil {
+			return nil, err
+		}
+		table = append(table, v)
+	}
+	return table, nil
+}
+
+// Read a Node message
+func readNode(b []byte) (id, lat, lon int64, keys, vals []uint64, err error) {
+	p := protoBuf(b)
+	for !p.done() {
+		var field, wire int
+		if field, wire, err = p.key(); err != nil {
+			return
+		}
+		switch field {
+		case 1, 8, 9:
+			var v uint64
+			if v, err = p.varint(); err != nil {
+				return
+			}
+			switch field {
+			case 1:
+				id = zigzag(v)
+			case 8:
+				lat = zigzag(v)
+			case 9:
+				lon = zigzag(v)
+			}
+		case 2:
+			if keys, err = p.packed(wire, keys); err != nil {
+				return
+			}
+		case 3:
+			if vals, err = p.packed(wire, vals); err != nil {
+				return
+			}
+		default:
+			if err = p.skip(wire); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Call fn for every node of a DenseNodes message. Ids and coordinates
+// are delta coded, tags are a flat list of key/value string ids with
+// a 0 after the tags of each node.
+func readDenseNodes(b []byte, fn func(id, lat, lon int64, kv []uint64)) error {
+	var ids, lats, lons, kv []uint64
+	p := protoBuf(b)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			ids, err = p.packed(wire, ids)
+		case 8:
+			lats, err = p.packed(wire, lats)
+		case 9:
+			lons, err = p.packed(wire, lons)
+		case 10:
+			kv, err = p.packed(wire, kv)
+		default:
+			err = p.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(lats) != len(ids) || len(lons) != len(ids) {
+		return errors.New("dense nodes with mismatched arrays")
+	}
+
+	var id, lat, lon int64
+	pos := 0
+	for i := range ids {
+		id += zigzag(ids[i])
+		lat += zigzag(lats[i])
+		lon += zigzag(lons[i])
+
+		// Blocks without any tag have no keys_vals at all
+		if pos >= len(kv) {
+			fn(id, lat, lon, nil)
+			continue
+		}
+		start := pos
+		for pos < len(kv) && kv[pos] != 0 {
+			pos += 2
+		}
+		end := pos
+		if end > len(kv) {
+			end = len(kv)
+		}
+		fn(id, lat, lon, kv[start:end])
+		pos++ // skip the 0 delimiter
+	}
+	return nil
+}
+
+// Protocol buffer wire types
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+var errTruncated = errors.New("truncated protocol buffer message")
+
+// Minimal protocol buffer decoder over a byte slice
+type protoBuf []byte
+
+func (p *protoBuf) done() bool { return len(*p) == 0 }
+
+func (p *protoBuf) varint() (uint64, error) {
+	v, n := binary.Uvarint(*p)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	*p = (*p)[n:]
+	return v, nil
+}
+
+func (p *protoBuf) key() (int, int, error) {
+	v, err := p.varint()
+	return int(v >> 3), int(v & 7), err
+}
+
+func (p *protoBuf) bytes() ([]byte, error) {
+	n, err := p.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(*p)) {
+		return nil, errTruncated
+	}
+	v := (*p)[:n]
+	*p = (*p)[n:]
+	return v, nil
+}
+
+// Read a repeated varint field, packed or not, appending to list
+func (p *protoBuf) packed(wire int, list []uint64) ([]uint64, error) {
+	if wire == wireVarint {
+		v, err := p.varint()
+		return append(list, v), err
+	}
+	if wire != wireBytes {
+		return list, p.skip(wire)
+	}
+	b, err := p.bytes()
+	if err != nil {
+		return list, err
+	}
+	inner := protoBuf(b)
+	for !inner.done() {
+		v, err := inner.varint()
+		if err != nil {
+			return list, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (p *protoBuf) skip(wire int) error {
+	var n int
+	switch wire {
+	case wireVarint:
+		_, err := p.varint()
+		return err
+	case wireBytes:
+		_, err := p.bytes()
+		return err
+	case wire64:
+		n = 8
+	case wire32:
+		n = 4
+	default:
+		return fmt.Errorf("unsupported wire type %d", wire)
+	}
+	if n > len(*p) {
+		return errTruncated
+	}
+	*p = (*p)[n:]
+	return nil
+}
+
+// Decode a zigzag encoded sint64
+func zigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
@@ -0,0 +1,181 @@
+package importer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Protocol buffer message written field by field
+type message []byte
+
+func (m message) varint(field int, v uint64) message {
+	m = binary.AppendUvarint(m, uint64(field<<3|wireVarint))
+	return binary.AppendUvarint(m, v)
+}
+
+func (m message) bytes(field int, v []byte) message {
+	m = binary.AppendUvarint(m, uint64(field<<3|wireBytes))
+	m = binary.AppendUvarint(m, uint64(len(v)))
+	return append(m, v...)
+}
+
+func (m message) packed(field int, vs ...uint64) message {
+	var inner []byte
+	for _, v := range vs {
+		inner = binary.AppendUvarint(inner, v)
+	}
+	return m.bytes(field, inner)
+}
+
+// Zigzag encoding of a sint64
+func sint(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// Coordinate in units of the default granularity of 100 nanodegrees
+func nano(deg float64) int64 {
+	return int64(math.Round(deg * 1e7))
+}
+
+// String table shared by the blocks of the fixture
+var fixtureStrings = []string{"", "amenity", "cafe", "name", "Кофейня", "bar", "Бар", "restaurant", "Ресторан", "highway"}
+
+func stringTable() message {
+	var m message
+	for _, s := range fixtureStrings {
+		m = m.bytes(1, []byte(s))
+	}
+	return m
+}
+
+// Dense nodes with delta coded ids and coordinates
+func denseNodes(ids []int64, lats, lons []float64, kv []uint64) message {
+	var dids, dlats, dlons []uint64
+	var id, lat, lon int64
+	for i := range ids {
+		dids = append(dids, sint(ids[i]-id))
+		dlats = append(dlats, sint(nano(lats[i])-lat))
+		dlons = append(dlons, sint(nano(lons[i])-lon))
+		id, lat, lon = ids[i], nano(lats[i]), nano(lons[i])
+	}
+	m := message(nil).packed(1, dids...).packed(8, dlats...).packed(9, dlons...)
+	if kv != nil {
+		m = m.packed(10, kv...)
+	}
+	return m
+}
+
+func node(id int64, lat, lon float64, keys, vals []uint64) message {
+	return message(nil).
+		varint(1, sint(id)).
+		packed(2, keys...).
+		packed(3, vals...).
+		varint(8, sint(nano(lat))).
+		varint(9, sint(nano(lon)))
+}
+
+func block(groups ...message) message {
+	m := message(nil).bytes(1, stringTable())
+	for _, g := range groups {
+		m = m.bytes(2, g)
+	}
+	return m
+}
+
+// A blob with its header, raw or zlib compressed
+func fileBlock(t *testing.T, blobType string, data []byte, compressed bool) []byte {
+	var blob message
+	if compressed {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		blob = blob.varint(2, uint64(len(data))).bytes(3, buf.Bytes())
+	} else {
+		blob = blob.bytes(1, data)
+	}
+	header := message(nil).bytes(1, []byte(blobType)).varint(3, uint64(len(blob)))
+
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(header)))
+	out = append(out, header...)
+	return append(out, blob...)
+}
+
+func TestOSMPBFRead(t *testing.T) {
+	var file []byte
+	file = append(file, fileBlock(t, "OSMHeader", message(nil).bytes(4, []byte("OsmSchema-V0.6")), false)...)
+
+	// Raw blob: tagged and untagged dense nodes, and a plain node
+	file = append(file, fileBlock(t, "OSMData", block(
+		message(nil).bytes(2, denseNodes(
+			[]int64{101, 102, 105},
+			[]float64{55.75, 55.76, 55.7},
+			[]float64{37.62, 37.6, 37.5},
+			[]uint64{1, 2, 3, 4, 0, 0, 1, 5, 3, 6, 0},
+		)),
+		message(nil).bytes(1, node(200, 55.8, 37.7, []uint64{1, 3}, []uint64{7, 8})),
+	), false)...)
+
+	// Zlib blob: dense nodes of a block without any tag, and a plain node
+	// that is not an imported amenity
+	file = append(file, fileBlock(t, "OSMData", block(
+		message(nil).bytes(2, denseNodes(
+			[]int64{300, 301},
+			[]float64{55.1, 55.2},
+			[]float64{37.1, 37.2},
+			nil,
+		)),
+		message(nil).bytes(1, node(400, 55.9, 37.9, []uint64{9}, []uint64{5})),
+		message(nil).bytes(1, node(401, -33.5, -70.5, []uint64{1}, []uint64{2})),
+	), true)...)
+
+	rows, err := OSMPBF{}.Read(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Row{
+		{Line: 1, ID: "osm-node-101", Ref: "node/101", Name: "Кофейня", Lat: "55.75", Lon: "37.62"},
+		{Line: 2, ID: "osm-node-105", Ref: "node/105", Name: "Бар", Lat: "55.7", Lon: "37.5"},
+		{Line: 3, ID: "osm-node-200", Ref: "node/200", Name: "Ресторан", Lat: "55.8", Lon: "37.7"},
+		{Line: 4, ID: "osm-node-401", Ref: "node/401", Lat: "-33.5", Lon: "-70.5"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows:\n got %+v\nwant %+v", rows, want)
+	}
+}
+
+func TestReadDenseNodesWithoutTags(t *testing.T) {
+	var tags [][]uint64
+	err := readDenseNodes(denseNodes([]int64{1, 2, 3}, []float64{1, 2, 3}, []float64{1, 2, 3}, nil), func(id, lat, lon int64, kv []uint64) {
+		tags = append(tags, kv)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 3 {
+		t.Fatalf("got %d nodes, want 3", len(tags))
+	}
+	for i, kv := range tags {
+		if len(kv) != 0 {
+			t.Errorf("node %d: got tags %v, want none", i, kv)
+		}
+	}
+}
+
+func TestParseBlobHeaderTooLarge(t *testing.T) {
+	for _, size := range []uint64{maxBlobSize + 1, 1 << 63, 1<<64 - 1} {
+		header := message(nil).bytes(1, []byte("OSMData")).varint(3, size)
+		if _, _, err := parseBlobHeader(header); err == nil {
+			t.Errorf("size %d: expected an error", size)
+		}
+	}
+}
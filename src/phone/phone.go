@@ -0,0 +1,61 @@
+// Package phone normalizes display phone numbers like "(499) 183-14-10"
+// to the E.164 format, e.g. "+74991831410".
+package phone
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Country calling code used for numbers written without one (Russia)
+const DefaultCountry = "7"
+
+// Normalize converts a single phone number to E.164.
+// It returns "" if the string does not look like a phone number.
+func Normalize(s string) string {
+	s = strings.TrimSpace(s)
+	international := strings.HasPrefix(s, "+")
+
+	var digits strings.Builder
+	for _, r := range s {
+		if unicode.IsDigit(r) {
+			digits.WriteRune(r)
+		}
+	}
+	d := digits.String()
+
+	switch {
+	case international:
+		// Already has a country code
+	case len(d) == 10:
+		d = DefaultCountry + d
+	case len(d) == 11 && d[0] == '8':
+		// Domestic trunk prefix
+		d = DefaultCountry + d[1:]
+	case len(d) == 11 && strings.HasPrefix(d, DefaultCountry):
+	default:
+		return ""
+	}
+
+	// E.164 allows at most 15 digits
+	if len(d) < 8 || len(d) > 15 {
+		return ""
+	}
+	return "+" + d
+}
+
+// NormalizeList splits a field holding several numbers separated by ";"
+// or "," and returns the distinct valid ones in E.164
+func NormalizeList(s string) []string {
+	numbers := make([]string, 0, 1)
+	seen := make(map[string]bool)
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == ',' }) {
+		n := Normalize(part)
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
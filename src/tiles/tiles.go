@@ -0,0 +1,156 @@
+// Package tiles does the arithmetic of the Web Mercator tile grid used by
+// web maps and by the geotile_grid aggregation of Elasticsearch.
+package tiles
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MaxZoom is the finest zoom level Elasticsearch aggregates tiles at
+const MaxZoom = 29
+
+// MaxLat is the latitude at which Web Mercator ends
+const MaxLat = 85.05112878
+
+// BBox is a bounding box in degrees
+type BBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+var ErrInvalidBBox = errors.New("bbox must be min_lon,min_lat,max_lon,max_lat")
+
+// ParseBBox parses "min_lon,min_lat,max_lon,max_lat", the order used by
+// GeoJSON and most map libraries
+func ParseBBox(s string) (BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BBox{}, ErrInvalidBBox
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return BBox{}, ErrInvalidBBox
+		}
+		v[i] = f
+	}
+	b := BBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	if b.MinLon < -180 || b.MaxLon > 180 || b.MinLat < -90 || b.MaxLat > 90 ||
+		b.MinLon >= b.MaxLon || b.MinLat >= b.MaxLat {
+		return BBox{}, ErrInvalidBBox
+	}
+	return b, nil
+}
+
+// String formats the box the way ParseBBox reads it
+func (b BBox) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+}
+
+// Tile is one square of the grid at a zoom level, y grows southwards
+type Tile struct {
+	Z, X, Y int
+}
+
+// String formats the tile as "z/x/y", the key of geotile_grid buckets
+func (t Tile) String() string {
+	return fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y)
+}
+
+var ErrInvalidTile = errors.New("tile must be z/x/y")
+
+// ParseTile parses "z/x/y"
+func ParseTile(s string) (Tile, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return Tile{}, ErrInvalidTile
+	}
+	var v [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return Tile{}, ErrInvalidTile
+		}
+		v[i] = n
+	}
+	t := Tile{Z: v[0], X: v[1], Y: v[2]}
+	if !t.Valid() {
+		return Tile{}, ErrInvalidTile
+	}
+	return t, nil
+}
+
+// Valid reports whether the tile exists at its zoom level
+func (t Tile) Valid() bool {
+	n := 1 << uint(t.Z)
+	return t.Z >= 0 && t.Z <= MaxZoom && t.X >= 0 && t.X < n && t.Y >= 0 && t.Y < n
+}
+
+// Bounds returns the box covered by a tile
+func (t Tile) Bounds() BBox {
+	n := float64(int(1) << uint(t.Z))
+	return BBox{
+		MinLon: float64(t.X)/n*360 - 180,
+		MaxLon: float64(t.X+1)/n*360 - 180,
+		MinLat: tileLat(float64(t.Y+1), n),
+		MaxLat: tileLat(float64(t.Y), n),
+	}
+}
+
+// Latitude of the northern edge of row y out of n
+func tileLat(y, n float64) float64 {
+	return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+}
+
+// Project returns the Web Mercator position of a point, from 0 to 1 from
+// the west and from the north edge of the map
+func Project(lat, lon float64) (float64, float64) {
+	lat = math.Max(-MaxLat, math.Min(MaxLat, lat))
+	r := lat * math.Pi / 180
+	x := (lon + 180) / 360
+	y := (1 - math.Log(math.Tan(r)+1/math.Cos(r))/math.Pi) / 2
+	return x, y
+}
+
+// At returns the tile containing a point at a zoom level
+func At(lat, lon float64, z int) Tile {
+	n := 1 << uint(z)
+	px, py := Project(lat, lon)
+	x := int(math.Floor(px * float64(n)))
+	y := int(math.Floor(py * float64(n)))
+	return Tile{Z: z, X: clamp(x, 0, n-1), Y: clamp(y, 0, n-1)}
+}
+
+// Covering returns the north-west and south-east tiles of the smallest
+// block of tiles at a zoom level that covers a box
+func Covering(b BBox, z int) (Tile, Tile) {
+	return At(b.MaxLat, b.MinLon, z), At(b.MinLat, b.MaxLon, z)
+}
+
+// Count returns how many tiles at a zoom level cover a box
+func Count(b BBox, z int) int {
+	nw, se := Covering(b, z)
+	return (se.X - nw.X + 1) * (se.Y - nw.Y + 1)
+}
+
+// Align grows a box to the edges of the tiles at a zoom level, so that
+// every tile touching it is wholly inside
+func Align(b BBox, z int) BBox {
+	nw, se := Covering(b, z)
+	north, south := nw.Bounds(), se.Bounds()
+	return BBox{MinLon: north.MinLon, MaxLat: north.MaxLat, MaxLon: south.MaxLon, MinLat: south.MinLat}
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
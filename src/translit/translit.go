@@ -0,0 +1,82 @@
+// Package translit normalizes Cyrillic and Latin spellings of Russian
+// names to a single folded form, so that a query typed in either script
+// or transliteration scheme matches the same places.
+package translit
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Cyrillic letters in the transliteration scheme used by the dataset
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "j", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "h", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shh", 'ъ': "",
+	'ы': "y", 'ь': "'", 'э': "e", 'ю': "ju", 'я': "ja",
+}
+
+// Spelling variants of the same sound, applied in order
+var variants = strings.NewReplacer(
+	"shch", "sh", "shh", "sh", "sch", "sh",
+	"tch", "ch",
+	"kh", "h",
+	"ts", "c", "tz", "c",
+	"je", "e", "ye", "e",
+	"x", "ks",
+	"w", "v",
+	"y", "i", "j", "i",
+)
+
+// ToLatin transliterates Cyrillic letters, other characters are kept as is
+func ToLatin(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		lower := unicode.ToLower(r)
+		if lat, ok := cyrillic[lower]; ok {
+			if lower != r && lat != "" {
+				// Keep the capital letter, "Я" becomes "Ja"
+				lat = strings.ToUpper(lat[:1]) + lat[1:]
+			}
+			b.WriteString(lat)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Fold returns the normalized form of s: lower case Latin words separated
+// by single spaces, with apostrophes, punctuation, spelling variants
+// (ja/ya/ia, ts/c, kh/h, ...) and doubled letters folded together.
+func Fold(s string) string {
+	s = strings.ToLower(ToLatin(s))
+
+	// Apostrophes are soft signs, drop them without splitting the word
+	s = strings.NewReplacer("'", "", "’", "", "`", "", "ʹ", "").Replace(s)
+
+	// Everything but letters and digits separates words
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, w := range words {
+		words[i] = squeeze(variants.Replace(w))
+	}
+	return strings.Join(words, " ")
+}
+
+// Collapse runs of the same letter into one
+func squeeze(s string) string {
+	var b strings.Builder
+	var prev rune
+	for i, r := range s {
+		if i > 0 && r == prev {
+			continue
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
@@ -0,0 +1,41 @@
+package translit
+
+import "testing"
+
+func TestFold(t *testing.T) {
+	// Spellings of one name that must fold to the same key
+	tests := []struct {
+		name     string
+		spelling []string
+	}{
+		{"request example", []string{"кафе академия", "kafe akademiya", "Kafe Akademija", "КАФЕ «Академия»"}},
+		{"soft sign and ts", []string{"Пицца Мельница", "pitstsa mel'nitsa", "Pitstsa Melnica"}},
+		{"kh and ё", []string{"Хлеб и ёлка", "khleb i elka", "Hleb i elka"}},
+		{"shch", []string{"Щи", "shchi", "shi"}},
+		{"x and doubled letters", []string{"Экспресс", "Express", "ekspres"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := Fold(tt.spelling[0])
+			for _, s := range tt.spelling[1:] {
+				if got := Fold(s); got != want {
+					t.Errorf("Fold(%q) = %q, want %q as for %q", s, got, want, tt.spelling[0])
+				}
+			}
+		})
+	}
+}
+
+func TestToLatin(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"кафе академия", "kafe akademija"},
+		{"Яблоко", "Jabloko"},
+		{"Cafe 24", "Cafe 24"},
+	}
+	for _, tt := range tests {
+		if got := ToLatin(tt.in); got != tt.want {
+			t.Errorf("ToLatin(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
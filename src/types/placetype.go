@@ -1,18 +1,308 @@
 package types
 
-import "errors"
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
 
 // Structure to represent a place for recomendations page
 type RecPlace struct {
-	ID       int
-	Name     string
-	Address  string
-	Phone    string
-	Location Location
+	// Number of the place as before ids of other forms were imported,
+	// 0 if its id is not a number
+	ID int
+	// Id of the place in any form
+	PlaceID    string
+	Name       string
+	Address    string
+	Phone      string
+	Phones     []string
+	Categories []string
+	Location   Location
+
+	// Average of the published reviews, nil if there are none
+	Rating *Rating
+
+	// How the place was ranked, only if requested
+	Score *RecScore
+
+	// Opening hours and, if they are known, the state at the requested time
+	OpeningHours string
+	OpenNow      *bool
+	NextOpen     *time.Time
+	NextClose    *time.Time
+}
+
+// NumericID returns the number of an id, 0 if it is not one
+func NumericID(id string) int {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return 0
+	}
+	return n
 }
 
 type Location struct {
 	Lat, Lon float64
 }
 
+// Mean radius of the Earth in meters
+const earthRadius = 6371008.8
+
+// Distance returns the great circle distance between two points in meters
+func Distance(a, b Location) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Structure to represent a name completion for the search box
+type Suggestion struct {
+	// Same as in RecPlace
+	ID      int
+	PlaceID string
+	Name    string
+	Address string
+}
+
+// Filter restricts a list of places, empty fields match everything
+type Filter struct {
+	City     string
+	Street   string
+	Phone    string
+	Category string
+
+	// Only places open at this time, if set
+	OpenAt time.Time
+
+	// Selected values of each facet. A place must match every facet, and
+	// any of the values of a facet unless AllValues is set.
+	Facets    map[string][]string
+	AllValues bool
+
+	// Places left out, by id
+	ExcludeIDs []string
+}
+
+// IsEmpty reports whether the filter matches every place
+func (f Filter) IsEmpty() bool {
+	return f.City == "" && f.Street == "" && f.Phone == "" && f.Category == "" && f.OpenAt.IsZero() && len(f.Facets) == 0 && len(f.ExcludeIDs) == 0
+}
+
+// Facets places are counted and filtered by
+const (
+	FacetCategory = "category"
+	FacetArea     = "area"
+	FacetStreet   = "street"
+	FacetHasPhone = "has_phone"
+	FacetChain    = "chain"
+)
+
+// Facets in the order they are shown
+var FacetNames = []string{FacetCategory, FacetArea, FacetStreet, FacetChain, FacetHasPhone}
+
+// FacetValue is the number of places with one value of a facet
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
 var ErrInvalidPage = errors.New("Invalid page value")
+
+var ErrNotFound = errors.New("Place not found")
+
+var ErrReviewNotFound = errors.New("Review not found")
+
+// Rating is the aggregate of the published reviews of a place
+type Rating struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+// Statuses of a review, only published reviews are shown and rated
+const (
+	ReviewPublished = "published"
+	ReviewHidden    = "hidden"
+)
+
+// Review is the rating and opinion of a user about a place
+type Review struct {
+	ID        string    `json:"id"`
+	PlaceID   string    `json:"place_id"`
+	User      string    `json:"user"`
+	Rating    int       `json:"rating"`
+	Text      string    `json:"text"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var ErrListNotFound = errors.New("List not found")
+
+// UserData is what a signed-in user saved: favorite places, oldest first,
+// and named lists
+type UserData struct {
+	User      string      `json:"user"`
+	Favorites []string    `json:"favorites"`
+	Lists     []PlaceList `json:"lists"`
+	// Learned from the history of the user
+	Preferences Preferences `json:"preferences"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// Preferences of a user: a weight per category, positive if they like
+// places of it, negative if they do not
+type Preferences struct {
+	Categories map[string]float64 `json:"categories"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+}
+
+// PlaceList is a named, ordered list of place ids. Shared lists can be
+// read by anyone who knows their share id.
+type PlaceList struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Places    []string  `json:"places"`
+	Shared    bool      `json:"shared"`
+	ShareID   string    `json:"share_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var ErrTooFar = errors.New("Too far from the place")
+
+var ErrTooSoon = errors.New("Already checked in at the place")
+
+// Checkin is a visit of a user to a place
+type Checkin struct {
+	ID      string    `json:"id"`
+	PlaceID string    `json:"place_id"`
+	User    string    `json:"user"`
+	At      time.Time `json:"at"`
+	// Where the user was and how far from the place, if they said
+	Location  *GeoPoint `json:"location,omitempty"`
+	DistanceM *float64  `json:"distance_m,omitempty"`
+}
+
+// GeoPoint is a location as stored in the index
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+var ErrTooManyCells = errors.New("The box has too many cells at this zoom")
+
+// Cluster is a group of nearby places shown as one marker: the places of
+// a map tile, at their centroid
+type Cluster struct {
+	Key      string   `json:"key"`
+	Count    int      `json:"count"`
+	Location GeoPoint `json:"location"`
+}
+
+// MapPlace is a place shown as its own marker
+type MapPlace struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Category []string `json:"category"`
+	Location GeoPoint `json:"location"`
+}
+
+// DensityCell is the number of places in a map tile and, if asked for,
+// the mean rating of the rated ones
+type DensityCell struct {
+	Key    string   `json:"key"`
+	Count  int      `json:"count"`
+	Rating *float64 `json:"rating,omitempty"`
+}
+
+// Orders of search results
+const (
+	SortRelevance = "relevance"
+	SortPopular   = "popular"
+)
+
+// Ranking presets of recommendations
+const (
+	ModeNearest  = "nearest"
+	ModeBalanced = "balanced"
+	ModeBest     = "best"
+)
+
+// RankProfile weighs the factors of a recommendation. The score of a
+// place is the distance decay multiplied by the weighted sum of the others.
+type RankProfile struct {
+	// Distance in meters at which the decay halves the score
+	Scale float64 `json:"scale_m"`
+	// Part of the score every place gets, whatever its rating
+	Distance float64 `json:"distance"`
+	Rating   float64 `json:"rating"`
+	Reviews  float64 `json:"reviews"`
+	Category float64 `json:"category"`
+}
+
+// RecOptions change how recommendations are ranked
+type RecOptions struct {
+	Profile RankProfile
+	// Categories preferred by the category weight
+	Prefer []string
+	// Return the score breakdown of each place
+	Explain bool
+
+	// Variety of the recommended places
+	Diversity Diversity
+
+	// Category weights of the user to re-rank by, nil if not personalized
+	Personal map[string]float64
+
+	// Number of places, the default if zero
+	Limit int
+}
+
+// Diversity configures how similar recommended places may be
+type Diversity struct {
+	// One place per chain, places with the same normalized name
+	Chains bool
+	// Prefer places of other categories than the ones already picked
+	Categories bool
+	// Trade-off between ranking (1) and variety (0)
+	Lambda float64
+}
+
+// RecScore is the breakdown of the score of a recommended place
+type RecScore struct {
+	DistanceM float64 `json:"distance_m"`
+	Decay     float64 `json:"decay"`
+	Distance  float64 `json:"distance"`
+	Rating    float64 `json:"rating"`
+	Reviews   float64 `json:"reviews"`
+	Category  float64 `json:"category"`
+	Total     float64 `json:"total"`
+	// Affinity of the user to the categories, if personalized
+	Personal *float64 `json:"personal,omitempty"`
+}
+
+// LessID orders place ids numerically, the ids of the dataset, followed by
+// the other ids in string order
+func LessID(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return x < y
+	case errA == nil:
+		return true
+	case errB == nil:
+		return false
+	}
+	return a < b
+}
+
+// SortIDs sorts place ids in the order of LessID
+func SortIDs(ids []string) {
+	sort.Slice(ids, func(i, j int) bool { return LessID(ids[i], ids[j]) })
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"day03es/db"
+	"day03es/types"
+)
+
+// Middleware that only lets requests with a valid admin token through
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return validateToken(func(w http.ResponseWriter, r *http.Request) {
+		user := userFromRequest(r)
+		if user == nil || !user.Admin {
+			http.Error(w, "Admin rights required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// Merge duplicate places into a canonical one
+func mergeHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var request struct {
+			Canonical  string   `json:"canonical"`
+			Duplicates []string `json:"duplicates"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.Canonical == "" || len(request.Duplicates) == 0 {
+			http.Error(w, "'canonical' and 'duplicates' are required", http.StatusBadRequest)
+			return
+		}
+
+		place, err := store.MergePlaces(request.Canonical, request.Duplicates)
+		if err != nil {
+			switch {
+			case errors.Is(err, types.ErrNotFound):
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case errors.Is(err, db.ErrMergeIntoSelf):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		response := map[string]interface{}{
+			"name":  "Merged",
+			"place": placeToJSON(*place),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(response); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -2,7 +2,10 @@ package web
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt"
 	"net/http"
@@ -10,9 +13,16 @@ import (
 	"time"
 )
 
-var secretKey = []byte("secret_key")
+// SecretKey signs and checks the tokens. Without it no token is issued or
+// accepted and the admin routes are not registered.
+var SecretKey []byte
+
+var errNoSecretKey = errors.New("no key to sign the tokens, set JWT_SECRET or -jwt-secret")
 var username = "username"
 
+// Key of the claims in the request context
+type ctxKey struct{}
+
 // User struct for JWT claims
 type User struct {
 	Name  string `json:"name"`
@@ -20,8 +30,15 @@ type User struct {
 	jwt.StandardClaims
 }
 
+// Issue a token with a subject of its own, the saved data of a user is
+// keyed by it
 func getTokenHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := createToken(username)
+	subject, err := newSubject()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	token, err := createToken(username, subject, false)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
@@ -34,18 +51,41 @@ func getTokenHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func createToken(username string) (string, error) {
+// AdminToken returns a token that gives access to the admin API
+func AdminToken(name string) (string, error) {
+	return createToken(name, name, true)
+}
+
+// UserToken returns a token of a regular user
+func UserToken(name string) (string, error) {
+	return createToken(name, name, false)
+}
+
+// Random subject of an anonymous user
+func newSubject() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "u-" + hex.EncodeToString(b), nil
+}
+
+func createToken(username, subject string, admin bool) (string, error) {
+	if len(SecretKey) == 0 {
+		return "", errNoSecretKey
+	}
 	// Create a new User struct
 	user := User{
 		Name:  username,
-		Admin: false,
+		Admin: admin,
 		StandardClaims: jwt.StandardClaims{
+			Subject:   subject,
 			ExpiresAt: time.Now().Add(24 * time.Hour).Unix(), // Token expires in 24 hours
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, user)
-	tokenString, err := token.SignedString(secretKey)
+	tokenString, err := token.SignedString(SecretKey)
 	if err != nil {
 		return "", err
 	}
@@ -53,6 +93,21 @@ func createToken(username string) (string, error) {
 	return tokenString, nil
 }
 
+// Key of the data of a user, tokens issued before the subject claim was
+// set are keyed by name
+func (u *User) key() string {
+	if u.Subject != "" {
+		return u.Subject
+	}
+	return u.Name
+}
+
+// Tokens issued by /get_token before it set a subject of their own all
+// have the same key, their users would share their data
+func (u *User) shared() bool {
+	return u.key() == username
+}
+
 // JWT middleware to validate the token
 func validateToken(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -62,6 +117,33 @@ func validateToken(next http.HandlerFunc) http.HandlerFunc {
 			http.Error(w, "Authorization token missing", http.StatusUnauthorized)
 			return
 		}
+		withUser(next)(w, r)
+	}
+}
+
+// JWT middleware for routes anonymous users can use too: a request
+// without a token goes through without a user
+func optionalToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next(w, r)
+			return
+		}
+		withUser(next)(w, r)
+	}
+}
+
+// Check the token of the request and add its claims to the context
+func withUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+
+		// An empty key would let anyone sign a token for any user and
+		// reach their reviews and check-ins
+		if len(SecretKey) == 0 {
+			http.Error(w, "Tokens are not accepted by this server", http.StatusUnauthorized)
+			return
+		}
 
 		// Check if the token is prefixed with "Bearer "
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
@@ -76,7 +158,7 @@ func validateToken(next http.HandlerFunc) http.HandlerFunc {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 			}
-			return secretKey, nil
+			return SecretKey, nil
 		})
 		if err != nil {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -86,7 +168,7 @@ func validateToken(next http.HandlerFunc) http.HandlerFunc {
 		// Check if the token is valid
 		if claims, ok := token.Claims.(*User); ok && token.Valid {
 			// Add the claims to the request context
-			ctx := context.WithValue(r.Context(), "user", claims)
+			ctx := context.WithValue(r.Context(), ctxKey{}, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		} else {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -94,3 +176,25 @@ func validateToken(next http.HandlerFunc) http.HandlerFunc {
 		}
 	}
 }
+
+// The authenticated user of a request, nil if anonymous
+func userFromRequest(r *http.Request) *User {
+	user, _ := r.Context().Value(ctxKey{}).(*User)
+	return user
+}
+
+// The authenticated user of a request. Writes 401 and returns nil if
+// there is none, e.g. when the route lacks the validateToken middleware,
+// or if the token is shared by every user.
+func requireUser(w http.ResponseWriter, r *http.Request) *User {
+	user := userFromRequest(r)
+	if user == nil {
+		http.Error(w, "Authorization token missing", http.StatusUnauthorized)
+		return nil
+	}
+	if user.shared() {
+		http.Error(w, "Token is shared by every user, get a new one", http.StatusUnauthorized)
+		return nil
+	}
+	return user
+}
@@ -0,0 +1,170 @@
+package web
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"day03es/db"
+)
+
+// Cache-Control policies per route
+const (
+	cachePlaces    = "public, max-age=300"
+	cacheHTML      = "public, max-age=60"
+	cacheRecommend = "public, max-age=600"
+	cachePrivate   = "private, max-age=600"
+	cacheNone      = "no-store"
+)
+
+// Size of a coordinate grid cell for recommendations, in degrees (~100 m)
+const gridStep = 0.001
+
+// Longest max-age of responses that depend on the current time, such as
+// the open state of places
+const cacheOpenMaxAge = 60
+
+// Middleware that sets the Cache-Control header of a route.
+// Error responses are never marked as cacheable.
+func cacheControl(policy string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(&cacheWriter{ResponseWriter: w, policy: policy}, r)
+	}
+}
+
+// Change the Cache-Control policy of a response that is not written yet,
+// e.g. when it depends on the user
+func setCachePolicy(w http.ResponseWriter, policy string) {
+	if cw, ok := w.(*cacheWriter); ok {
+		cw.policy = policy
+	}
+}
+
+// Lower the max-age of the Cache-Control policy of a response that is not
+// written yet, keeping whether it is public or private
+func limitMaxAge(w http.ResponseWriter, seconds int) {
+	cw, ok := w.(*cacheWriter)
+	if !ok {
+		return
+	}
+	directives := strings.Split(cw.policy, ", ")
+	for i, d := range directives {
+		if v, ok := strings.CutPrefix(d, "max-age="); ok {
+			if age, err := strconv.Atoi(v); err == nil && age > seconds {
+				directives[i] = "max-age=" + strconv.Itoa(seconds)
+			}
+		}
+	}
+	cw.policy = strings.Join(directives, ", ")
+}
+
+// ResponseWriter that adds the Cache-Control header on successful responses
+// and keeps the ETag only on successful and not modified ones
+type cacheWriter struct {
+	http.ResponseWriter
+	policy      string
+	wroteHeader bool
+}
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		if code < http.StatusBadRequest {
+			cw.Header().Set("Cache-Control", cw.policy)
+		} else {
+			cw.Header().Set("Cache-Control", cacheNone)
+		}
+		if (code < http.StatusOK || code >= http.StatusMultipleChoices) && code != http.StatusNotModified {
+			cw.Header().Del("ETag")
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *cacheWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Build a strong ETag from the index generation and the request parameters
+func makeETag(gen string, parts ...string) string {
+	h := sha1.New()
+	h.Write([]byte(gen))
+	for _, p := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(p))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil))[:20] + `"`
+}
+
+// Set the ETag of the response and answer 304 if the client already has it.
+// Returns true if the response has been written. The ETag is dropped by
+// cacheControl if the handler fails later.
+func notModified(w http.ResponseWriter, r *http.Request, store db.Store, parts ...string) bool {
+	gen, err := store.Generation()
+	if err != nil {
+		// Caching is an optimisation, serve the request anyway
+		log.Println("notModified:", err)
+		return false
+	}
+
+	etag := makeETag(gen, parts...)
+	w.Header().Set("ETag", etag)
+
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// Like notModified, for responses that show ratings or visits: their ETag
+// also changes with every review and check-in
+func notModifiedActivity(w http.ResponseWriter, r *http.Request, store db.Store, parts ...string) bool {
+	activity, err := store.ActivityGeneration()
+	if err != nil {
+		log.Println("notModifiedActivity:", err)
+		return false
+	}
+	return notModified(w, r, store, append([]string{activity}, parts...)...)
+}
+
+// Check if an If-None-Match header matches the ETag (weak comparison),
+// in any content encoding
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+		for _, enc := range encodings {
+			if candidate == encodedETag(etag, enc) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Snap a coordinate to the center of its grid cell so that nearby
+// requests share one cached response
+func snapToGrid(v float64) float64 {
+	cell := math.Floor(v/gridStep)*gridStep + gridStep/2
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(cell, 'f', 6, 64), 64)
+	return rounded
+}
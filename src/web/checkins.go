@@ -0,0 +1,117 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"day03es/db"
+	"day03es/types"
+)
+
+// CheckinDistance is how far in meters from a place a user may check in.
+// If it is not 0, a check-in must say where the user is.
+var CheckinDistance = 200.0
+
+// CheckinInterval is how long a user waits before checking in at the
+// same place again, 0 lets them check in any time
+var CheckinInterval = time.Hour
+
+// Check the authenticated user in at a place, the body may give where
+// they are: {"lat": 55.75, "lon": 37.62}
+func checkinHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, _ := placeIDFromPath(r.URL.Path)
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+
+		var request struct {
+			Lat *float64 `json:"lat"`
+			Lon *float64 `json:"lon"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		checkin := types.Checkin{PlaceID: id, User: user.key()}
+		switch {
+		case request.Lat == nil && request.Lon == nil:
+			if CheckinDistance > 0 {
+				http.Error(w, "'lat' and 'lon' are required", http.StatusBadRequest)
+				return
+			}
+		case request.Lat == nil || request.Lon == nil:
+			http.Error(w, "'lat' and 'lon' must be given together", http.StatusBadRequest)
+			return
+		case *request.Lat < -90 || *request.Lat > 90 || *request.Lon < -180 || *request.Lon > 180:
+			http.Error(w, "Invalid 'lat' or 'lon'", http.StatusBadRequest)
+			return
+		default:
+			checkin.Location = &types.GeoPoint{Lat: *request.Lat, Lon: *request.Lon}
+		}
+
+		saved, err := store.AddCheckin(checkin, CheckinDistance, CheckinInterval)
+		if err == types.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if errors.Is(err, types.ErrTooFar) {
+			// Says how far
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		} else if errors.Is(err, types.ErrTooSoon) {
+			// Says when the user may check in again
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"name":    "Checkin",
+			"checkin": saved,
+		})
+	}
+}
+
+// Check-ins of the authenticated user, newest first
+func checkinsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		page, err := getPageFromRequest(r)
+		if err != nil || page < 1 {
+			http.Error(w, fmt.Sprintf("Invalid page value: '%d'", page), http.StatusBadRequest)
+			return
+		}
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+
+		checkins, total, err := store.ListCheckins(user.key(), limit, (page-1)*limit)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":     "Checkins",
+			"total":    total,
+			"checkins": checkins,
+		})
+	}
+}
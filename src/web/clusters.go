@@ -0,0 +1,78 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"day03es/db"
+	"day03es/tiles"
+	"day03es/types"
+)
+
+// Clusters are the tiles this many zoom levels below the map zoom, 8x8
+// clusters per map tile
+const clusterDetail = 3
+
+// Default and largest number of places under which a tile shows them
+// instead of a cluster
+const (
+	defaultClusterThreshold = 5
+	maxClusterThreshold     = 100
+)
+
+// Clusters of places in ?bbox=min_lon,min_lat,max_lon,max_lat at ?zoom=N
+func clustersHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		bbox, err := tiles.ParseBBox(query.Get("bbox"))
+		if err != nil {
+			http.Error(w, "Invalid 'bbox' parameter, "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		zoom, err := strconv.Atoi(query.Get("zoom"))
+		if err != nil || zoom < 0 || zoom > tiles.MaxZoom {
+			http.Error(w, fmt.Sprintf("Invalid 'zoom' parameter, expected 0 to %d", tiles.MaxZoom), http.StatusBadRequest)
+			return
+		}
+		threshold := defaultClusterThreshold
+		if v := query.Get("threshold"); v != "" {
+			threshold, err = strconv.Atoi(v)
+			if err != nil || threshold < 1 || threshold > maxClusterThreshold {
+				http.Error(w, fmt.Sprintf("Invalid 'threshold' parameter, expected 1 to %d", maxClusterThreshold), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Whole tiles are aggregated, so a cluster does not change when
+		// the map is panned
+		precision := zoom + clusterDetail
+		if precision > tiles.MaxZoom {
+			precision = tiles.MaxZoom
+		}
+		bbox = tiles.Align(bbox, precision)
+
+		filter := getFilterFromRequest(r)
+		if notModified(w, r, store, "clusters", bbox.String(), strconv.Itoa(precision), strconv.Itoa(threshold), fmt.Sprintf("%+v", filter)) {
+			return
+		}
+
+		clusters, places, err := store.Clusters(bbox, precision, filter, threshold)
+		if err == types.ErrTooManyCells {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":      "Clusters",
+			"zoom":      zoom,
+			"precision": precision,
+			"bbox":      []float64{bbox.MinLon, bbox.MinLat, bbox.MaxLon, bbox.MaxLat},
+			"clusters":  clusters,
+			"places":    places,
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+
+	"day03es/db"
+	"day03es/tiles"
+	"day03es/types"
+)
+
+// Moscow, shown when no box is given
+const defaultBBox = "37.3,55.55,37.95,55.95"
+
+// Tiles of about 1.4 km in Moscow
+const defaultDensityPrecision = 14
+
+// Width of the heatmap in pixels
+const heatmapWidth = 800
+
+// Parameters of a density request, the box is aligned to the tiles
+type densityRequest struct {
+	BBox       tiles.BBox
+	Precision  int
+	WithRating bool
+	Filter     types.Filter
+}
+
+// Read ?bbox=, ?precision=, ?rating=true and the place filters
+func getDensityFromRequest(r *http.Request) (densityRequest, error) {
+	query := r.URL.Query()
+	req := densityRequest{
+		Precision:  defaultDensityPrecision,
+		WithRating: query.Get("rating") == "true",
+		Filter:     getFilterFromRequest(r),
+	}
+
+	box := query.Get("bbox")
+	if box == "" {
+		box = defaultBBox
+	}
+	bbox, err := tiles.ParseBBox(box)
+	if err != nil {
+		return req, fmt.Errorf("Invalid 'bbox' parameter, %s", err)
+	}
+	if v := query.Get("precision"); v != "" {
+		req.Precision, err = strconv.Atoi(v)
+		if err != nil || req.Precision < 0 || req.Precision > tiles.MaxZoom {
+			return req, fmt.Errorf("Invalid 'precision' parameter, expected 0 to %d", tiles.MaxZoom)
+		}
+	}
+
+	// Cells on the edges are counted whole
+	req.BBox = tiles.Align(bbox, req.Precision)
+	return req, nil
+}
+
+// Fetch the density of a request, writes the error response if it fails
+func fetchDensity(w http.ResponseWriter, store db.Store, req densityRequest) ([]types.DensityCell, bool) {
+	cells, err := store.Density(req.BBox, req.Precision, req.Filter, req.WithRating)
+	if err == types.ErrTooManyCells {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return cells, true
+}
+
+// Number of places per map tile as JSON, or as GeoJSON polygons with
+// ?format=geojson
+func densityHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := getDensityFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "geojson" {
+			http.Error(w, "Invalid 'format' parameter, expected json or geojson", http.StatusBadRequest)
+			return
+		}
+
+		// Mean ratings change with the reviews, counts only with the index
+		check := notModified
+		if req.WithRating {
+			check = notModifiedActivity
+		}
+		if check(w, r, store, "density", format, req.BBox.String(), strconv.Itoa(req.Precision),
+			strconv.FormatBool(req.WithRating), fmt.Sprintf("%+v", req.Filter)) {
+			return
+		}
+
+		cells, ok := fetchDensity(w, store, req)
+		if !ok {
+			return
+		}
+
+		if format == "geojson" {
+			w.Header().Set("Content-Disposition", `attachment; filename="density.geojson"`)
+			writeGeoJSON(w, densityToGeoJSON(cells))
+			return
+		}
+
+		max := 0
+		for _, c := range cells {
+			if c.Count > max {
+				max = c.Count
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":      "Density",
+			"precision": req.Precision,
+			"bbox":      []float64{req.BBox.MinLon, req.BBox.MinLat, req.BBox.MaxLon, req.BBox.MaxLat},
+			"max":       max,
+			"cells":     cells,
+		})
+	}
+}
+
+// GeoJSON feature collection with a polygon per cell
+func densityToGeoJSON(cells []types.DensityCell) map[string]interface{} {
+	features := make([]interface{}, 0, len(cells))
+	for _, c := range cells {
+		t, err := tiles.ParseTile(c.Key)
+		if err != nil {
+			continue
+		}
+		b := t.Bounds()
+		properties := map[string]interface{}{"key": c.Key, "count": c.Count}
+		if c.Rating != nil {
+			properties["rating"] = *c.Rating
+		}
+		features = append(features, map[string]interface{}{
+			"type": "Feature",
+			"geometry": map[string]interface{}{
+				"type": "Polygon",
+				// Counterclockwise, as RFC 7946 asks
+				"coordinates": [][][2]float64{{
+					{b.MinLon, b.MinLat}, {b.MaxLon, b.MinLat}, {b.MaxLon, b.MaxLat}, {b.MinLon, b.MaxLat}, {b.MinLon, b.MinLat},
+				}},
+			},
+			"properties": properties,
+		})
+	}
+	return map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	}
+}
+
+// Write a GeoJSON response
+func writeGeoJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/geo+json")
+	writeJSON(w, http.StatusOK, response)
+}
+
+// struct to represent a cell of the heatmap in the HTML template
+type DensityCellHTML struct {
+	X, Y, Width, Height float64
+	Fill                string
+	Opacity             float64
+	Title               string
+}
+
+// Heatmap template, an SVG drawn on the server
+const densityTemplate = `
+<!doctype html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Density</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+
+<body>
+<form method="get" action="/stats/density">
+	<label>Precision <input type="number" name="precision" min="0" max="{{.MaxZoom}}" value="{{.Precision}}"></label>
+	<label>Category <input type="text" name="category" value="{{.Category}}"></label>
+	<label><input type="checkbox" name="rating" value="true"{{if .WithRating}} checked{{end}}> Mean rating</label>
+	<input type="hidden" name="bbox" value="{{.BBox}}">
+	<button type="submit">Show</button>
+</form>
+<h5>Places: {{.Total}}, most in a cell: {{.Max}}</h5>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+	<rect width="{{.Width}}" height="{{.Height}}" fill="#f4f4f4" stroke="#999"/>
+	{{range .Cells}}
+	<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}" fill="{{.Fill}}" fill-opacity="{{.Opacity}}"><title>{{.Title}}</title></rect>
+	{{end}}
+</svg>
+<div>
+	<a href="/api/v1/stats/density?{{.Query}}">JSON</a>
+	<a href="/api/v1/stats/density?{{.Query}}&amp;format=geojson">GeoJSON</a>
+</div>
+</body>
+</html>
+`
+
+// Heatmap of the density as an HTML page
+func densityPageHandler(store db.Store) http.HandlerFunc {
+	tmpl := template.Must(template.New("densityTemplate").Parse(densityTemplate))
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := getDensityFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		check := notModified
+		if req.WithRating {
+			check = notModifiedActivity
+		}
+		if check(w, r, store, "density.html", req.BBox.String(), strconv.Itoa(req.Precision),
+			strconv.FormatBool(req.WithRating), fmt.Sprintf("%+v", req.Filter)) {
+			return
+		}
+
+		cells, ok := fetchDensity(w, store, req)
+		if !ok {
+			return
+		}
+
+		// Web Mercator pixels of the box
+		x0, y0 := tiles.Project(req.BBox.MaxLat, req.BBox.MinLon)
+		x1, y1 := tiles.Project(req.BBox.MinLat, req.BBox.MaxLon)
+		scale := heatmapWidth / (x1 - x0)
+
+		total, max := 0, 0
+		for _, c := range cells {
+			total += c.Count
+			if c.Count > max {
+				max = c.Count
+			}
+		}
+		rects := make([]DensityCellHTML, 0, len(cells))
+		for _, c := range cells {
+			t, err := tiles.ParseTile(c.Key)
+			if err != nil {
+				continue
+			}
+			b := t.Bounds()
+			cx0, cy0 := tiles.Project(b.MaxLat, b.MinLon)
+			cx1, cy1 := tiles.Project(b.MinLat, b.MaxLon)
+
+			// From yellow for one place to red for the densest cell
+			heat := math.Log1p(float64(c.Count)) / math.Log1p(float64(max))
+			title := fmt.Sprintf("%d places", c.Count)
+			if c.Rating != nil {
+				title += fmt.Sprintf(", rated %.2f", *c.Rating)
+			}
+			rects = append(rects, DensityCellHTML{
+				X:       round2((cx0 - x0) * scale),
+				Y:       round2((cy0 - y0) * scale),
+				Width:   round2((cx1 - cx0) * scale),
+				Height:  round2((cy1 - cy0) * scale),
+				Fill:    fmt.Sprintf("hsl(%.0f, 100%%, 50%%)", 60*(1-heat)),
+				Opacity: round2(0.3 + 0.6*heat),
+				Title:   title,
+			})
+		}
+
+		// Same request without the format, for the export links
+		query := r.URL.Query()
+		query.Set("bbox", req.BBox.String())
+		query.Del("format")
+
+		data := struct {
+			Cells      []DensityCellHTML
+			Width      float64
+			Height     float64
+			Total      int
+			Max        int
+			MaxZoom    int
+			Precision  int
+			Category   string
+			WithRating bool
+			BBox       string
+			Query      template.URL
+		}{
+			Cells:      rects,
+			Width:      heatmapWidth,
+			Height:     round2((y1 - y0) * scale),
+			Total:      total,
+			Max:        max,
+			MaxZoom:    tiles.MaxZoom,
+			Precision:  req.Precision,
+			Category:   req.Filter.Category,
+			WithRating: req.WithRating,
+			BBox:       req.BBox.String(),
+			Query:      template.URL(query.Encode()),
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// Keep two decimals of a pixel coordinate
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
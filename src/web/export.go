@@ -0,0 +1,163 @@
+package web
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"day03es/db"
+)
+
+// Content types of the export formats
+var exportTypes = map[string]string{
+	"ndjson":  "application/x-ndjson",
+	"csv":     "text/csv; charset=utf-8",
+	"geojson": "application/geo+json",
+}
+
+// Number of places written between two flushes of the response
+const exportFlushEvery = 500
+
+// Stream every place of the index in the requested format
+func exportHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "ndjson"
+		}
+		contentType, ok := exportTypes[format]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Invalid 'format' parameter: '%s'", format), http.StatusBadRequest)
+			return
+		}
+
+		if notModifiedActivity(w, r, store, "export", format) {
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"places.%s\"", format))
+
+		// write encodes one place, flush empties the encoder buffer
+		// and finish closes the document
+		var write func(db.Place) error
+		flush := func() error { return nil }
+		finish := func() error { return nil }
+		switch format {
+		case "ndjson":
+			enc := json.NewEncoder(w)
+			write = func(p db.Place) error {
+				if place := placeToJSON(p); place != nil {
+					return enc.Encode(place)
+				}
+				return nil
+			}
+		case "csv":
+			cw := csv.NewWriter(w)
+			if err := cw.Write([]string{"id", "name", "address", "phone", "lat", "lon"}); err != nil {
+				return
+			}
+			write = func(p db.Place) error {
+				if _, _, ok := placeLocation(p); !ok {
+					return nil
+				}
+				src := p.Source
+				return cw.Write([]string{p.ID, src.Name, src.Address, src.Phone, src.Location.Lat, src.Location.Lon})
+			}
+			flush = func() error { cw.Flush(); return cw.Error() }
+			finish = flush
+		case "geojson":
+			write, finish = geoJSONWriter(w)
+		}
+
+		// Flush regularly so the client starts receiving data right away
+		flusher, _ := w.(http.Flusher)
+		count := 0
+		err := store.ScanPlaces(r.Context(), func(p db.Place) error {
+			if err := write(p); err != nil {
+				return err
+			}
+			count++
+			if flusher != nil && count%exportFlushEvery == 0 {
+				if err := flush(); err != nil {
+					return err
+				}
+				flusher.Flush()
+			}
+			return nil
+		})
+		if err == nil {
+			err = finish()
+		}
+
+		// Headers are already sent, so the error can only be logged
+		if err != nil {
+			log.Println("exportHandler:", err)
+		}
+	}
+}
+
+// Coordinates of a place, false if they cannot be parsed. Such places are
+// left out of every export format.
+func placeLocation(p db.Place) (float64, float64, bool) {
+	lat, err := strconv.ParseFloat(p.Source.Location.Lat, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lon, err := strconv.ParseFloat(p.Source.Location.Lon, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+// Returns functions that stream a GeoJSON FeatureCollection
+func geoJSONWriter(w http.ResponseWriter) (func(db.Place) error, func() error) {
+	first := true
+	write := func(p db.Place) error {
+		lat, lon, ok := placeLocation(p)
+		if !ok {
+			return nil
+		}
+		feature := map[string]interface{}{
+			"type": "Feature",
+			"id":   p.ID,
+			"geometry": map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []float64{lon, lat},
+			},
+			"properties": map[string]interface{}{
+				"name":    p.Source.Name,
+				"address": p.Source.Address,
+				"phone":   p.Source.Phone,
+			},
+		}
+		data, err := json.Marshal(feature)
+		if err != nil {
+			return err
+		}
+
+		prefix := ",\n"
+		if first {
+			prefix = `{"type":"FeatureCollection","features":[` + "\n"
+			first = false
+		}
+		if _, err := w.Write([]byte(prefix)); err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	finish := func() error {
+		tail := "\n]}\n"
+		if first {
+			tail = `{"type":"FeatureCollection","features":[]}` + "\n"
+		}
+		_, err := w.Write([]byte(tail))
+		return err
+	}
+	return write, finish
+}
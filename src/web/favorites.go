@@ -0,0 +1,357 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"day03es/db"
+	"day03es/types"
+)
+
+// Limits of the lists of a user
+const (
+	maxLists          = 50
+	maxListPlaces     = 100
+	maxListNameLength = 100
+)
+
+// Shared lists change without a new index generation
+const cacheShared = "public, max-age=60"
+
+// Id following a route prefix, e.g. "/api/v1/me/lists/ab12" with
+// "/me/lists/" gives "ab12"
+func idFromPath(path, prefix string) string {
+	i := strings.Index(path, prefix)
+	if i < 0 {
+		return ""
+	}
+	return strings.Trim(path[i+len(prefix):], "/")
+}
+
+// Favorite places of the authenticated user, oldest first
+func favoritesHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+
+		data, err := store.GetUserData(user.key())
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		places, err := store.GetPlacesByID(data.Favorites)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		// Current ids, a favorite saved before its place was merged has
+		// the id of the canonical place
+		favorites := make([]string, len(places))
+		for i, place := range places {
+			favorites[i] = place.ID
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":      "Favorites",
+			"favorites": favorites,
+			"places":    placesToJSON(places),
+		})
+	}
+}
+
+// Category preferences learned from the history of the authenticated
+// user, used by personalized recommendations
+func preferencesHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+
+		data, err := store.GetUserData(user.key())
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		categories := data.Preferences.Categories
+		if categories == nil {
+			categories = map[string]float64{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":       "Preferences",
+			"categories": categories,
+			"updated_at": data.Preferences.UpdatedAt,
+		})
+	}
+}
+
+// Add a place to the favorites of the authenticated user with PUT, or
+// remove it with DELETE
+func favoriteHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := idFromPath(r.URL.Path, "/me/favorites/")
+		if id == "" {
+			http.NotFound(w, r)
+			return
+		}
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+
+		var err error
+		switch r.Method {
+		case http.MethodPut:
+			_, err = store.AddFavorite(user.key(), id)
+		case http.MethodDelete:
+			_, err = store.RemoveFavorite(user.key(), id)
+		default:
+			w.Header().Set("Allow", "PUT, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err == types.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Lists of the authenticated user with GET, create one with POST
+// {"name": "Lunch near office", "places": ["12", "7"], "shared": false}
+func listsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			data, err := store.GetUserData(user.key())
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			lists := make([]map[string]interface{}, len(data.Lists))
+			for i, list := range data.Lists {
+				lists[i] = listToJSON(list, nil)
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"name":  "Lists",
+				"lists": lists,
+			})
+
+		case http.MethodPost:
+			list, ok := getListFromRequest(w, r)
+			if !ok {
+				return
+			}
+			data, err := store.GetUserData(user.key())
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if len(data.Lists) >= maxLists {
+				http.Error(w, fmt.Sprintf("A user can have at most %d lists", maxLists), http.StatusBadRequest)
+				return
+			}
+			saveList(w, store, user, list, http.StatusCreated)
+
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// One list of the authenticated user with its places: GET it, replace
+// its name, places and sharing with PUT, or DELETE it
+func listHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := idFromPath(r.URL.Path, "/me/lists/")
+		if id == "" {
+			http.NotFound(w, r)
+			return
+		}
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			data, err := store.GetUserData(user.key())
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			for _, list := range data.Lists {
+				if list.ID == id {
+					writeList(w, store, list, true)
+					return
+				}
+			}
+			http.Error(w, types.ErrListNotFound.Error(), http.StatusNotFound)
+
+		case http.MethodPut:
+			list, ok := getListFromRequest(w, r)
+			if !ok {
+				return
+			}
+			list.ID = id
+			saveList(w, store, user, list, http.StatusOK)
+
+		case http.MethodDelete:
+			err := store.DeleteList(user.key(), id)
+			if err == types.ErrListNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Read-only view of a shared list, anyone with the link can read it
+func sharedListHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		shareID := idFromPath(r.URL.Path, "/lists/")
+		if shareID == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		list, err := store.GetSharedList(shareID)
+		if err == types.ErrListNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeList(w, store, *list, false)
+	}
+}
+
+// Decode and check the list in a request body, writes the error response
+// if it is invalid
+func getListFromRequest(w http.ResponseWriter, r *http.Request) (types.PlaceList, bool) {
+	var request struct {
+		Name   string   `json:"name"`
+		Places []string `json:"places"`
+		Shared bool     `json:"shared"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return types.PlaceList{}, false
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		http.Error(w, "Missing 'name'", http.StatusBadRequest)
+		return types.PlaceList{}, false
+	}
+	if utf8.RuneCountInString(request.Name) > maxListNameLength {
+		http.Error(w, fmt.Sprintf("'name' is longer than %d characters", maxListNameLength), http.StatusBadRequest)
+		return types.PlaceList{}, false
+	}
+	if len(request.Places) > maxListPlaces {
+		http.Error(w, fmt.Sprintf("A list can have at most %d places", maxListPlaces), http.StatusBadRequest)
+		return types.PlaceList{}, false
+	}
+	if request.Places == nil {
+		request.Places = []string{}
+	}
+	return types.PlaceList{Name: request.Name, Places: request.Places, Shared: request.Shared}, true
+}
+
+// Create or replace a list of the user and write it back
+func saveList(w http.ResponseWriter, store db.Store, user *User, list types.PlaceList, status int) {
+	saved, err := store.PutList(user.key(), list)
+	if errors.Is(err, types.ErrNotFound) {
+		// Names the unknown id
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err == types.ErrListNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"name": "List",
+		"list": listToJSON(*saved, nil),
+	})
+}
+
+// Write a list with its places, owners also see how it is shared
+func writeList(w http.ResponseWriter, store db.Store, list types.PlaceList, owner bool) {
+	places, err := store.GetPlacesByID(list.Places)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	response := listToJSON(list, places)
+	if !owner {
+		delete(response, "id")
+		delete(response, "shared")
+		delete(response, "share_url")
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name": "List",
+		"list": response,
+	})
+}
+
+// JSON representation of a list, with its places if they were loaded
+func listToJSON(list types.PlaceList, places []db.Place) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":         list.ID,
+		"name":       list.Name,
+		"places":     list.Places,
+		"shared":     list.Shared,
+		"created_at": list.CreatedAt,
+		"updated_at": list.UpdatedAt,
+	}
+	if list.Shared {
+		response["share_url"] = apiV1 + "/lists/" + list.ShareID
+	}
+	if places != nil {
+		response["places"] = placesToJSON(places)
+	}
+	return response
+}
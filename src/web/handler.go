@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"day03es/db"
 	"day03es/types"
@@ -16,9 +17,24 @@ import (
 
 // struct to represent a place in the HTML template
 type PlaceHTML struct {
-	Name    string
-	Address string
-	Phone   string
+	ID       string
+	Name     string
+	Address  string
+	Phone    string
+	Lat, Lon string
+}
+
+// struct to represent a facet with its checkboxes in the HTML template
+type FacetHTML struct {
+	Name   string
+	Values []FacetValueHTML
+}
+
+type FacetValueHTML struct {
+	Value   string
+	Count   int
+	Param   string
+	Checked bool
 }
 
 // HTML template
@@ -33,26 +49,126 @@ const htmlTemplate = `
 </head>
 
 <body>
+<nav><a href="/map">Map</a> <a href="/stats/density">Density</a></nav>
+<div>
+	<input id="search" type="search" list="suggestions" placeholder="Search places" autocomplete="off">
+	<datalist id="suggestions"></datalist>
+</div>
+<form method="get" action="/">
+	{{range .Facets}}
+	<fieldset>
+		<legend>{{.Name}}</legend>
+		{{range .Values}}
+		<label><input type="checkbox" name="f" value="{{.Param}}"{{if .Checked}} checked{{end}}> {{.Value}} ({{.Count}})</label><br>
+		{{end}}
+	</fieldset>
+	{{end}}
+	<label><input type="checkbox" name="op" value="and"{{if .AllValues}} checked{{end}}> Match all selected values of a facet</label>
+	<button type="submit">Filter</button>
+</form>
 <h5>Total: {{.Total}}</h5>
 <ul>
 	{{range .Places}}
 	<li>
-			<div>{{.Name}}</div>
+			<div><button type="button" class="star" data-id="{{.ID}}" title="Add to favorites">&#9734;</button> <a href="/places/{{.ID}}">{{.Name}}</a></div>
 			<div>{{.Address}}</div>
 			<div>{{.Phone}}</div>
+			<div>{{.Lat}}, {{.Lon}}</div>
 	</li>
 	{{end}}
 </ul>
 <div>
-    <a href="/?page=1">First</a>
+    <a href="/?page=1{{.Query}}">First</a>
     {{if .PrevPage}}
-    <a href="/?page={{.PrevPage}}">Previous</a>
+    <a href="/?page={{.PrevPage}}{{.Query}}">Previous</a>
     {{end}}
     {{if ne .NextPage 0}}
-    <a href="/?page={{.NextPage}}">Next</a>
+    <a href="/?page={{.NextPage}}{{.Query}}">Next</a>
     {{end}}
-    <a href="/?page={{.TotalPages}}">Last</a>
+    <a href="/?page={{.TotalPages}}{{.Query}}">Last</a>
 </div>
+<script>
+(function() {
+	var input = document.getElementById("search");
+	var list = document.getElementById("suggestions");
+	var timer;
+	input.addEventListener("input", function() {
+		clearTimeout(timer);
+		var q = input.value.trim();
+		if (q === "") {
+			return;
+		}
+		// Wait until the user stops typing for a moment
+		timer = setTimeout(function() {
+			fetch("/api/v1/suggest?q=" + encodeURIComponent(q))
+				.then(function(res) { return res.json(); })
+				.then(function(data) {
+					list.innerHTML = "";
+					data.suggestions.forEach(function(s) {
+						var option = document.createElement("option");
+						option.value = s.Name;
+						option.label = s.Address;
+						list.appendChild(option);
+					});
+				});
+		}, 150);
+	});
+})();
+
+// Stars of the favorite places, the API token is kept in the browser
+(function() {
+	var stars = document.querySelectorAll(".star");
+	var favorites = {};
+	function paint() {
+		stars.forEach(function(star) {
+			var on = !!favorites[star.dataset.id];
+			star.innerHTML = on ? "&#9733;" : "&#9734;";
+			star.title = on ? "Remove from favorites" : "Add to favorites";
+		});
+	}
+	function request(method, path) {
+		return fetch("/api/v1/me/favorites" + path, {
+			method: method,
+			headers: {"Authorization": "Bearer " + localStorage.getItem("token")}
+		}).then(function(res) {
+			if (res.status === 401) {
+				localStorage.removeItem("token");
+			}
+			if (!res.ok) {
+				throw new Error(res.statusText);
+			}
+			return res;
+		});
+	}
+	function load() {
+		request("GET", "").then(function(res) { return res.json(); }).then(function(data) {
+			favorites = {};
+			data.favorites.forEach(function(id) { favorites[id] = true; });
+			paint();
+		}).catch(function() {});
+	}
+	stars.forEach(function(star) {
+		star.addEventListener("click", function() {
+			if (!localStorage.getItem("token")) {
+				var token = prompt("Sign in: paste your API token");
+				if (!token) {
+					return;
+				}
+				localStorage.setItem("token", token.trim());
+				load();
+			}
+			var id = star.dataset.id;
+			request(favorites[id] ? "DELETE" : "PUT", "/" + encodeURIComponent(id)).then(function() {
+				favorites[id] = !favorites[id];
+				paint();
+			}).catch(function() {});
+		});
+	});
+	if (localStorage.getItem("token")) {
+		load();
+	}
+})();
+</script>
 </body>
 </html>
 `
@@ -62,32 +178,65 @@ const limit = 10
 
 func CreateServer(store db.Store, fAuth bool) error {
 
-	// Define a handler function to handle incoming HTTP requests
-	mainHandler := func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasPrefix(r.URL.Path, "/api/") {
-			JSONHandler(store)(w, r)
-		} else {
-			HTMLHandler(store)(w, r)
-		}
+	mux := http.NewServeMux()
+
+	if fAuth && len(SecretKey) == 0 {
+		return errNoSecretKey
 	}
 
 	// Different recHandler func with or without authentification
 	var recommendHandler http.HandlerFunc
 	if fAuth {
-		recommendHandler = validateToken(recHandler(store))
-		http.HandleFunc("/api/get_token", getTokenHandler)
+		recommendHandler = cacheControl(cachePrivate, validateToken(recHandler(store)))
+		handleAPI(mux, "/get_token", cacheControl(cacheNone, getTokenHandler))
 	} else {
-		recommendHandler = recHandler(store)
+		recommendHandler = cacheControl(cacheRecommend, optionalToken(recHandler(store)))
 	}
 
-	// Register the handler function with the default ServeMux
-	http.HandleFunc("/", mainHandler)
-	http.HandleFunc("/api/recommend", recommendHandler)
+	// Register the API routes, unknown paths under /api/ get 404
+	handleAPI(mux, "/places", cacheControl(cachePlaces, JSONHandler(store)))
+	handleAPI(mux, "/places/export", cacheControl(cachePlaces, exportHandler(store)))
+	handleAPI(mux, "/places/", placeRoutes(store))
+	handleAPI(mux, "/recommend", recommendHandler)
+	handleAPI(mux, "/suggest", cacheControl(cacheSuggest, suggestHandler(store)))
+	handleAPI(mux, "/clusters", cacheControl(cachePlaces, clustersHandler(store)))
+	handleAPI(mux, "/stats/density", cacheControl(cachePlaces, densityHandler(store)))
+	handleAPI(mux, "/search", cacheControl(cachePlaces, searchHandler(store)))
+	// Admin tokens could be forged without a key of our own
+	if len(SecretKey) > 0 {
+		handleAPI(mux, "/admin/merge", cacheControl(cacheNone, requireAdmin(mergeHandler(store))))
+		handleAPI(mux, "/admin/reviews", cacheControl(cacheNone, requireAdmin(adminReviewsHandler(store))))
+		handleAPI(mux, "/admin/reviews/", cacheControl(cacheNone, requireAdmin(moderateReviewHandler(store))))
+	} else {
+		fmt.Println("No JWT_SECRET, the admin and user APIs are off")
+	}
+	// The data of a user is keyed by the subject of the token, so it is
+	// only reachable with tokens nobody else can sign
+	if len(SecretKey) > 0 {
+		handleAPI(mux, "/me/favorites", cacheControl(cacheNone, validateToken(favoritesHandler(store))))
+		handleAPI(mux, "/me/favorites/", cacheControl(cacheNone, validateToken(favoriteHandler(store))))
+		handleAPI(mux, "/me/lists", cacheControl(cacheNone, validateToken(listsHandler(store))))
+		handleAPI(mux, "/me/lists/", cacheControl(cacheNone, validateToken(listHandler(store))))
+		handleAPI(mux, "/me/checkins", cacheControl(cacheNone, validateToken(checkinsHandler(store))))
+		handleAPI(mux, "/me/preferences", cacheControl(cacheNone, validateToken(preferencesHandler(store))))
+	}
+	handleAPI(mux, "/lists/", cacheControl(cacheShared, sharedListHandler(store)))
+	mux.HandleFunc("/api/", http.NotFound)
+
+	mux.HandleFunc("/stats/density", cacheControl(cacheHTML, densityPageHandler(store)))
+	mux.HandleFunc("/map", cacheControl(cacheHTML, mapHandler(store)))
+	mux.HandleFunc("/places/", cacheControl(cacheHTML, placePageHandler(store)))
+
+	// Vector tiles for map libraries
+	mux.HandleFunc("/tiles/", cacheControl(cacheTiles, tilesHandler(store)))
+
+	// HTML interface
+	mux.HandleFunc("/", exactPath("/", cacheControl(cacheHTML, HTMLHandler(store))))
 
 	// Start the HTTP server and listen for incoming requests on port 8888
 	fmt.Println("Server is running on port 8888...")
 
-	return http.ListenAndServe(":8888", nil)
+	return http.ListenAndServe(":8888", compress(mux))
 }
 
 func recHandler(store db.Store) http.HandlerFunc {
@@ -121,17 +270,68 @@ func recHandler(store db.Store) http.HandlerFunc {
 			}
 		}
 
+		openAt, err := getOpenAtFromRequest(r)
+		if err != nil {
+			http.Error(w, "Invalid 'open_at' parameter", http.StatusBadRequest)
+			return
+		}
+
+		opts, err := getRecOptionsFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Signed-in users can have the places re-ranked by their history
+		if r.URL.Query().Get("personalize") == "true" {
+			w.Header().Add("Vary", "Authorization")
+			if user := userFromRequest(r); user != nil && !user.shared() {
+				data, err := store.GetUserData(user.key())
+				if err != nil {
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				opts.Personal = data.Preferences.Categories
+				setCachePolicy(w, cachePrivate)
+			}
+		}
+
+		// The places open at a time are only cached for a short while
+		if !openAt.IsZero() {
+			limitMaxAge(w, cacheOpenMaxAge)
+		}
+
+		// Recommendations are computed for the grid cell of the point
+		lat, lon = snapToGrid(lat), snapToGrid(lon)
+		filter := types.Filter{Category: strings.TrimSpace(r.URL.Query().Get("category")), OpenAt: openAt}
+
+		// The open state changes with time, so does the response
+		now := time.Now().Truncate(time.Minute).Format(time.RFC3339)
+		if notModifiedActivity(w, r, store, "recommend", fmt.Sprint(lat), fmt.Sprint(lon), filter.Category, openAt.Format(time.RFC3339), now,
+			fmt.Sprintf("%+v", opts)) {
+			return
+		}
+
 		// Get recommended places via ES query
-		places, err := store.GetRecommended(lat, lon)
+		places, err := store.GetRecommended(lat, lon, filter, opts)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
+		// Categories of the recommended places
+		counts := make(map[string]int)
+		for _, place := range places {
+			for _, c := range place.Categories {
+				counts[c]++
+			}
+		}
+
 		// Construct the response JSON
 		response := map[string]interface{}{
-			"name":   "Recommendation",
-			"places": places,
+			"name":       "Recommendation",
+			"places":     places,
+			"categories": counts,
 		}
 
 		// Set the Content-Type header to application/json
@@ -149,8 +349,12 @@ func recHandler(store db.Store) http.HandlerFunc {
 
 func JSONHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if notModifiedActivity(w, r, store, "places", r.URL.Query().Encode()) {
+			return
+		}
 
-		page, _, places, totalPlaces, err := handlerHelper(r, store)
+		filter := getFilterFromRequest(r)
+		page, _, places, totalPlaces, err := handlerHelper(r, store, filter)
 
 		if err == types.ErrInvalidPage {
 			errMsg := fmt.Sprintf("Invalid page value: '%d'", page)
@@ -162,11 +366,27 @@ func JSONHandler(store db.Store) http.HandlerFunc {
 			return
 		}
 
+		// Facets of every place matching the filter, not only this page
+		facets, err := store.Facets("", filter)
+		if err != nil {
+			log.Println("JSONHandler:", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		counts, err := store.CategoryCounts(filter)
+		if err != nil {
+			log.Println("JSONHandler:", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
 		// Prepare the response data in JSON format
 		response := map[string]interface{}{
-			"name":   "Places",
-			"total":  totalPlaces,
-			"places": placesToJSON(places),
+			"name":       "Places",
+			"total":      totalPlaces,
+			"categories": counts,
+			"facets":     facets,
+			"places":     placesToJSON(places),
 		}
 
 		// Encode the response data as JSON with indentation
@@ -184,31 +404,43 @@ func JSONHandler(store db.Store) http.HandlerFunc {
 func placesToJSON(places []db.Place) []map[string]interface{} {
 	result := make([]map[string]interface{}, len(places))
 	for i, place := range places {
-		lat, err := strconv.ParseFloat(place.Source.Location.Lat, 64)
-		if err != nil {
-			continue
-		}
-		lon, err := strconv.ParseFloat(place.Source.Location.Lon, 64)
-		if err != nil {
-			continue
-		}
-		result[i] = map[string]interface{}{
-			"id":      place.ID,
-			"name":    place.Source.Name,
-			"address": place.Source.Address,
-			"phone":   place.Source.Phone,
-			"location": map[string]float64{
-				"lat": lat,
-				"lon": lon,
-			},
-		}
+		result[i] = placeToJSON(place)
 	}
 	return result
 }
 
+// Convert a single place to JSON format, nil if its location is invalid
+func placeToJSON(place db.Place) map[string]interface{} {
+	lat, lon, ok := placeLocation(place)
+	if !ok {
+		return nil
+	}
+	return map[string]interface{}{
+		"id":             place.ID,
+		"name":           place.Source.Name,
+		"address":        place.Source.Address,
+		"address_parsed": place.Source.AddressParsed,
+		"phone":          place.Source.Phone,
+		"phones":         place.Source.Phones,
+		"category":       place.Source.Category,
+		"opening_hours":  place.Source.OpeningHours,
+		"rating":         place.Source.Rating,
+		"visits":         place.Source.Visits,
+		"location": map[string]float64{
+			"lat": lat,
+			"lon": lon,
+		},
+	}
+}
+
 func HTMLHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		page, totalPages, places, totalPlaces, err := handlerHelper(r, store)
+		if notModified(w, r, store, "html", r.URL.Query().Encode()) {
+			return
+		}
+
+		filter := getFilterFromRequest(r)
+		page, totalPages, places, totalPlaces, err := handlerHelper(r, store, filter)
 
 		// Check if the page value is within the valid range
 		if err == types.ErrInvalidPage {
@@ -223,12 +455,27 @@ func HTMLHandler(store db.Store) http.HandlerFunc {
 			return
 		}
 
+		facets, err := store.Facets("", filter)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			log.Println("HTMLHandler 3:", err)
+			return
+		}
+
+		// Keep the filters when following the pagination links
+		query := r.URL.Query()
+		query.Del("page")
+		params := ""
+		if len(query) > 0 {
+			params = "&" + query.Encode()
+		}
+
 		// Render the HTML response with the list of places and pagination links
-		renderHTMLResponse(w, places, totalPlaces, page, totalPages)
+		renderHTMLResponse(w, places, totalPlaces, page, totalPages, facetsToHTML(facets, filter), filter.AllValues, template.URL(params))
 	}
 }
 
-func handlerHelper(r *http.Request, store db.Store) (int, int, []db.Place, int, error) {
+func handlerHelper(r *http.Request, store db.Store, filter types.Filter) (int, int, []db.Place, int, error) {
 	page, err := getPageFromRequest(r)
 
 	if err != nil {
@@ -241,7 +488,7 @@ func handlerHelper(r *http.Request, store db.Store) (int, int, []db.Place, int,
 		totalPlaces int
 	)
 
-	places, totalPlaces, err = store.GetPlaces(limit, (page-1)*limit)
+	places, totalPlaces, err = store.GetPlaces(limit, (page-1)*limit, filter)
 	if err != nil && err != types.ErrInvalidPage {
 		log.Println("handleHelper 2:", err)
 		return page, 0, nil, 0, err
@@ -274,15 +521,95 @@ func getPageFromRequest(r *http.Request) (int, error) {
 	return page, nil
 }
 
+// Extract the time places must be open at from request URL: open_at as
+// RFC 3339, or now with open_now=true. Zero if neither is set.
+func getOpenAtFromRequest(r *http.Request) (time.Time, error) {
+	if at := r.URL.Query().Get("open_at"); at != "" {
+		return time.Parse(time.RFC3339, at)
+	}
+	if r.URL.Query().Get("open_now") == "true" {
+		return time.Now(), nil
+	}
+	return time.Time{}, nil
+}
+
+// Extract place filters from request URL
+func getFilterFromRequest(r *http.Request) types.Filter {
+	query := r.URL.Query()
+	filter := types.Filter{
+		City:      strings.TrimSpace(query.Get("city")),
+		Street:    strings.TrimSpace(query.Get("street")),
+		Phone:     strings.TrimSpace(query.Get("phone")),
+		Category:  strings.TrimSpace(query.Get("category")),
+		AllValues: query.Get("op") == "and",
+	}
+
+	// Facet values are given as f=<facet>:<value>, unknown facets are ignored
+	for _, param := range query["f"] {
+		name, value, _ := strings.Cut(param, ":")
+		value = strings.TrimSpace(value)
+		if !isFacet(name) || value == "" {
+			continue
+		}
+		if filter.Facets == nil {
+			filter.Facets = make(map[string][]string)
+		}
+		filter.Facets[name] = append(filter.Facets[name], value)
+	}
+	return filter
+}
+
+func isFacet(name string) bool {
+	for _, facet := range types.FacetNames {
+		if name == facet {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the checkboxes of the facets, selected values are always listed
+func facetsToHTML(facets map[string][]types.FacetValue, filter types.Filter) []FacetHTML {
+	result := make([]FacetHTML, 0, len(types.FacetNames))
+	for _, name := range types.FacetNames {
+		selected := make(map[string]bool)
+		for _, value := range filter.Facets[name] {
+			selected[value] = true
+		}
+
+		facet := FacetHTML{Name: name}
+		for _, v := range facets[name] {
+			facet.Values = append(facet.Values, FacetValueHTML{
+				Value:   v.Value,
+				Count:   v.Count,
+				Param:   name + ":" + v.Value,
+				Checked: selected[v.Value],
+			})
+			delete(selected, v.Value)
+		}
+		for _, value := range filter.Facets[name] {
+			if selected[value] {
+				facet.Values = append(facet.Values, FacetValueHTML{Value: value, Param: name + ":" + value, Checked: true})
+				delete(selected, value)
+			}
+		}
+		result = append(result, facet)
+	}
+	return result
+}
+
 // RenderHTMLResponse generates HTML content with the list of places and pagination links
-func renderHTMLResponse(w http.ResponseWriter, places []db.Place, totalPlaces, page, totalPages int) {
+func renderHTMLResponse(w http.ResponseWriter, places []db.Place, totalPlaces, page, totalPages int, facets []FacetHTML, allValues bool, query template.URL) {
 	// Create a slice to hold the place data for rendering in the HTML template
 	placeHTMLs := make([]PlaceHTML, len(places))
 	for i, place := range places {
 		placeHTMLs[i] = PlaceHTML{
+			ID:      place.ID,
 			Name:    place.Source.Name,
 			Address: place.Source.Address,
 			Phone:   place.Source.Phone,
+			Lat:     place.Source.Location.Lat,
+			Lon:     place.Source.Location.Lon,
 		}
 	}
 
@@ -303,6 +630,9 @@ func renderHTMLResponse(w http.ResponseWriter, places []db.Place, totalPlaces, p
 		TotalPages int
 		PrevPage   int
 		NextPage   int
+		Facets     []FacetHTML
+		AllValues  bool
+		Query      template.URL
 	}{
 		Places:     placeHTMLs,
 		Total:      totalPlaces,
@@ -311,6 +641,9 @@ func renderHTMLResponse(w http.ResponseWriter, places []db.Place, totalPlaces, p
 		TotalPages: totalPages,
 		PrevPage:   prevPage,
 		NextPage:   nextPage,
+		Facets:     facets,
+		AllValues:  allValues,
+		Query:      query,
 	}
 
 	// Render the HTML content to the response writer
@@ -0,0 +1,282 @@
+package web
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strings"
+
+	"day03es/db"
+	"day03es/tiles"
+)
+
+// Outline of Moscow drawn under the markers
+//
+//go:embed moscow.geojson
+var moscowGeoJSON []byte
+
+// Width of the map in pixels
+const mapWidth = 800
+
+// Margin around the outline, as a share of its size
+const mapMargin = 0.05
+
+// Size of a map tile in pixels, as map libraries draw them
+const tileSize = 256
+
+// Rings of the polygons of a GeoJSON feature collection
+func geoJSONRings(data []byte) ([][][2]float64, error) {
+	var fc struct {
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return nil, err
+	}
+
+	var rings [][][2]float64
+	for _, f := range fc.Features {
+		switch f.Geometry.Type {
+		case "Polygon":
+			var polygon [][][2]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &polygon); err != nil {
+				return nil, err
+			}
+			rings = append(rings, polygon...)
+		case "MultiPolygon":
+			var polygons [][][][2]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &polygons); err != nil {
+				return nil, err
+			}
+			for _, polygon := range polygons {
+				rings = append(rings, polygon...)
+			}
+		}
+	}
+	if len(rings) == 0 {
+		return nil, fmt.Errorf("geoJSONRings: no polygon")
+	}
+	return rings, nil
+}
+
+// Projected view of the map: the outline as an SVG path and what the
+// page needs to place markers and read clicks
+type mapView struct {
+	BBox      tiles.BBox
+	BBoxParam string
+	Path      string
+	Width     float64
+	Height    float64
+	X0, Y0    float64
+	Scale     float64
+	Zoom      int
+}
+
+// Fit a view around rings in Web Mercator
+func newMapView(rings [][][2]float64) mapView {
+	b := tiles.BBox{MinLon: 180, MinLat: 90, MaxLon: -180, MaxLat: -90}
+	for _, ring := range rings {
+		for _, p := range ring {
+			b.MinLon, b.MaxLon = math.Min(b.MinLon, p[0]), math.Max(b.MaxLon, p[0])
+			b.MinLat, b.MaxLat = math.Min(b.MinLat, p[1]), math.Max(b.MaxLat, p[1])
+		}
+	}
+	dLon, dLat := (b.MaxLon-b.MinLon)*mapMargin, (b.MaxLat-b.MinLat)*mapMargin
+	b = tiles.BBox{MinLon: b.MinLon - dLon, MinLat: b.MinLat - dLat, MaxLon: b.MaxLon + dLon, MaxLat: b.MaxLat + dLat}
+
+	v := mapView{BBox: b, BBoxParam: b.String(), Width: mapWidth}
+	v.X0, v.Y0 = tiles.Project(b.MaxLat, b.MinLon)
+	x1, y1 := tiles.Project(b.MinLat, b.MaxLon)
+	v.Scale = mapWidth / (x1 - v.X0)
+	v.Height = round2((y1 - v.Y0) * v.Scale)
+
+	// The zoom at which a map library would show the view at this size
+	v.Zoom = int(math.Floor(math.Log2(v.Scale / tileSize)))
+
+	var path strings.Builder
+	for _, ring := range rings {
+		for i, p := range ring {
+			x, y := v.pixel(p[1], p[0])
+			if i == 0 {
+				fmt.Fprintf(&path, "M%g %g", x, y)
+			} else {
+				fmt.Fprintf(&path, "L%g %g", x, y)
+			}
+		}
+		path.WriteString("Z")
+	}
+	v.Path = path.String()
+	return v
+}
+
+// Pixel of a point in the view
+func (v mapView) pixel(lat, lon float64) (float64, float64) {
+	x, y := tiles.Project(lat, lon)
+	return round2((x - v.X0) * v.Scale), round2((y - v.Y0) * v.Scale)
+}
+
+// Map page template, markers are loaded by the script
+const mapTemplate = `
+<!doctype html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Map</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<style>
+		#map { cursor: crosshair; }
+		.cluster { fill: #3873b5; fill-opacity: 0.6; }
+		.place { fill: #3873b5; }
+		.result { fill: #d7301f; stroke: #fff; stroke-width: 2; }
+		.here { fill: none; stroke: #d7301f; stroke-width: 2; }
+		text { font: 10px sans-serif; fill: #fff; text-anchor: middle; dominant-baseline: central; pointer-events: none; }
+	</style>
+</head>
+
+<body>
+<div>
+	<label>Category <input id="category" type="text"></label>
+	<span>Click the map to get recommendations for that point</span>
+</div>
+<svg id="map" xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}"
+	data-x0="{{.X0}}" data-y0="{{.Y0}}" data-scale="{{.Scale}}" data-zoom="{{.Zoom}}" data-bbox="{{.BBoxParam}}">
+	<rect width="{{.Width}}" height="{{.Height}}" fill="#eef2f5"/>
+	<path d="{{.Path}}" fill="#fff" stroke="#8a9aa8" stroke-width="1.5"/>
+	<g id="markers"></g>
+	<g id="results"></g>
+</svg>
+<ol id="recommended"></ol>
+<script>
+(function() {
+	var svg = document.getElementById("map");
+	var markers = document.getElementById("markers");
+	var results = document.getElementById("results");
+	var list = document.getElementById("recommended");
+	var category = document.getElementById("category");
+	var x0 = +svg.dataset.x0, y0 = +svg.dataset.y0, scale = +svg.dataset.scale;
+	var ns = "http://www.w3.org/2000/svg";
+
+	// Web Mercator, the same projection as the outline
+	function pixel(lat, lon) {
+		var r = lat * Math.PI / 180;
+		var x = (lon + 180) / 360;
+		var y = (1 - Math.log(Math.tan(r) + 1 / Math.cos(r)) / Math.PI) / 2;
+		return [(x - x0) * scale, (y - y0) * scale];
+	}
+	function toLocation(px, py) {
+		var x = px / scale + x0, y = py / scale + y0;
+		return {
+			lat: Math.atan(Math.sinh(Math.PI * (1 - 2 * y))) * 180 / Math.PI,
+			lon: x * 360 - 180
+		};
+	}
+	function circle(parent, lat, lon, r, cls, title) {
+		var p = pixel(lat, lon);
+		var c = document.createElementNS(ns, "circle");
+		c.setAttribute("cx", p[0]);
+		c.setAttribute("cy", p[1]);
+		c.setAttribute("r", r);
+		c.setAttribute("class", cls);
+		if (title) {
+			var t = document.createElementNS(ns, "title");
+			t.textContent = title;
+			c.appendChild(t);
+		}
+		parent.appendChild(c);
+		return p;
+	}
+	function filter() {
+		var c = category.value.trim();
+		return c ? "&category=" + encodeURIComponent(c) : "";
+	}
+
+	function loadMarkers() {
+		fetch("/api/v1/clusters?bbox=" + svg.dataset.bbox + "&zoom=" + svg.dataset.zoom + filter())
+			.then(function(res) { return res.json(); })
+			.then(function(data) {
+				markers.innerHTML = "";
+				data.clusters.forEach(function(c) {
+					var p = circle(markers, c.location.lat, c.location.lon, 6 + 2 * Math.log(c.count), "cluster", c.count + " places");
+					var t = document.createElementNS(ns, "text");
+					t.setAttribute("x", p[0]);
+					t.setAttribute("y", p[1]);
+					t.textContent = c.count;
+					markers.appendChild(t);
+				});
+				data.places.forEach(function(pl) {
+					circle(markers, pl.location.lat, pl.location.lon, 3, "place", pl.name);
+				});
+			});
+	}
+
+	svg.addEventListener("click", function(e) {
+		var pt = svg.createSVGPoint();
+		pt.x = e.clientX;
+		pt.y = e.clientY;
+		pt = pt.matrixTransform(svg.getScreenCTM().inverse());
+		var here = toLocation(pt.x, pt.y);
+
+		var headers = {};
+		if (localStorage.getItem("token")) {
+			headers["Authorization"] = "Bearer " + localStorage.getItem("token");
+		}
+		fetch("/api/v1/recommend?lat=" + here.lat.toFixed(6) + "&lon=" + here.lon.toFixed(6) + filter(), {headers: headers})
+			.then(function(res) {
+				if (!res.ok) {
+					throw new Error(res.statusText);
+				}
+				return res.json();
+			})
+			.then(function(data) {
+				results.innerHTML = "";
+				list.innerHTML = "";
+				circle(results, here.lat, here.lon, 8, "here");
+				data.places.forEach(function(pl) {
+					circle(results, pl.Location.Lat, pl.Location.Lon, 6, "result", pl.Name);
+					var li = document.createElement("li");
+					li.textContent = pl.Name + ", " + pl.Address;
+					list.appendChild(li);
+				});
+			})
+			.catch(function(err) {
+				list.innerHTML = "";
+				var li = document.createElement("li");
+				li.textContent = "No recommendations: " + err.message;
+				list.appendChild(li);
+			});
+	});
+
+	category.addEventListener("change", loadMarkers);
+	loadMarkers();
+})();
+</script>
+</body>
+</html>
+`
+
+// Map of the places over the outline of Moscow, without a tile server
+func mapHandler(store db.Store) http.HandlerFunc {
+	tmpl := template.Must(template.New("mapTemplate").Parse(mapTemplate))
+	rings, err := geoJSONRings(moscowGeoJSON)
+	if err != nil {
+		panic(err)
+	}
+	view := newMapView(rings)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if notModified(w, r, store, "map") {
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, view); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package web
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"day03es/db"
+	"day03es/types"
+)
+
+// Split the path of a place route into the place id and what follows it,
+// e.g. "/api/v1/places/12/checkin" gives "12" and "checkin"
+func placeIDFromPath(path string) (string, string) {
+	i := strings.Index(path, "/places/")
+	if i < 0 {
+		return "", ""
+	}
+	id, rest, _ := strings.Cut(path[i+len("/places/"):], "/")
+	return id, rest
+}
+
+// Routes under /places/<id>
+func placeRoutes(store db.Store) http.HandlerFunc {
+	place := cacheControl(cachePlaces, placeHandler(store))
+	reviews := reviewsHandler(store)
+	checkin := cacheControl(cacheNone, validateToken(checkinHandler(store)))
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, rest := placeIDFromPath(r.URL.Path)
+		switch {
+		case id == "":
+			http.NotFound(w, r)
+		case rest == "":
+			place(w, r)
+		case rest == "reviews":
+			reviews(w, r)
+		case rest == "checkin":
+			checkin(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// Single place as JSON with the places nearest to it. Ids of places
+// merged into another one redirect to the canonical place.
+func placeHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := placeIDFromPath(r.URL.Path)
+
+		if notModifiedActivity(w, r, store, "place", id) {
+			return
+		}
+
+		place, err := store.GetPlace(id)
+		if err == types.ErrNotFound {
+			redirectMerged(w, r, store, id)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		nearby, err := nearbyPlaces(store, *place)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		response := map[string]interface{}{
+			"name":   "Place",
+			"place":  placeToJSON(*place),
+			"nearby": nearbyToJSON(nearby),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(response); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// Redirect the id of a merged place to its canonical place, 404 otherwise
+func redirectMerged(w http.ResponseWriter, r *http.Request, store db.Store, id string) {
+	target, err := store.MergedInto(id)
+	if err == types.ErrNotFound {
+		http.Error(w, "Place not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the route around the id
+	i := strings.Index(r.URL.Path, "/places/") + len("/places/")
+	location := r.URL.Path[:i] + target + strings.TrimPrefix(r.URL.Path[i:], id)
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	w.Header().Del("ETag")
+	http.Redirect(w, r, location, http.StatusMovedPermanently)
+}
+
+// Number of other places shown near a place
+const nearbyLimit = 5
+
+// A place near another one
+type nearbyPlace struct {
+	Place     types.RecPlace
+	DistanceM float64
+}
+
+// The places nearest to a place, found by the recommendation query
+// without the place itself
+func nearbyPlaces(store db.Store, place db.Place) ([]nearbyPlace, error) {
+	lat, err := strconv.ParseFloat(place.Source.Location.Lat, 64)
+	if err != nil {
+		return []nearbyPlace{}, nil
+	}
+	lon, err := strconv.ParseFloat(place.Source.Location.Lon, 64)
+	if err != nil {
+		return []nearbyPlace{}, nil
+	}
+
+	opts := types.RecOptions{Profile: db.RankProfiles[types.ModeNearest], Limit: nearbyLimit}
+	places, err := store.GetRecommended(lat, lon, types.Filter{ExcludeIDs: []string{place.ID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	here := types.Location{Lat: lat, Lon: lon}
+	nearby := make([]nearbyPlace, len(places))
+	for i, p := range places {
+		nearby[i] = nearbyPlace{Place: p, DistanceM: math.Round(types.Distance(here, p.Location))}
+	}
+	return nearby, nil
+}
+
+// JSON representation of nearby places. The open state is left out, it
+// would change before the cached response expires.
+func nearbyToJSON(nearby []nearbyPlace) []map[string]interface{} {
+	result := make([]map[string]interface{}, len(nearby))
+	for i, n := range nearby {
+		result[i] = map[string]interface{}{
+			"id":         n.Place.PlaceID,
+			"name":       n.Place.Name,
+			"address":    n.Place.Address,
+			"category":   n.Place.Categories,
+			"rating":     n.Place.Rating,
+			"distance_m": n.DistanceM,
+			"location": map[string]float64{
+				"lat": n.Place.Location.Lat,
+				"lon": n.Place.Location.Lon,
+			},
+		}
+	}
+	return result
+}
@@ -0,0 +1,268 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"day03es/db"
+	"day03es/tiles"
+	"day03es/types"
+)
+
+// Size of the map of a place in pixels, and the margin around its points
+const (
+	placeMapWidth  = 320
+	placeMapHeight = 200
+	placeMapMargin = 24
+)
+
+// Smallest span of the map of a place in meters, when the nearby places
+// are very close or missing
+const placeMapMinSpan = 200
+
+// Length of the Equator in meters, the width of the Web Mercator world
+const earthCircumference = 40075016.686
+
+// Lengths a scale bar can have, in meters
+var scaleBarLengths = []float64{10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+
+// Longest scale bar in pixels
+const scaleBarMax = 100
+
+// struct to represent a point on the map of a place in the HTML template
+type MapPointHTML struct {
+	X, Y  float64
+	Label string
+	Title string
+}
+
+// struct to represent a nearby place in the HTML template
+type NearbyHTML struct {
+	ID        string
+	Label     string
+	Name      string
+	Address   string
+	DistanceM float64
+}
+
+// Place page template
+const placeTemplate = `
+<!doctype html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{.Name}}</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<style>
+		.place { fill: #d7301f; stroke: #fff; stroke-width: 2; }
+		.nearby { fill: #3873b5; stroke: #fff; stroke-width: 1; }
+		text { font: 10px sans-serif; }
+		.label { fill: #fff; text-anchor: middle; dominant-baseline: central; }
+	</style>
+</head>
+
+<body>
+<nav><a href="/">Places</a> <a href="/map">Map</a></nav>
+<h1>{{.Name}}</h1>
+<dl>
+	<dt>Address</dt><dd>{{.Address}}</dd>
+	{{range .AddressParts}}<dt>{{index . 0}}</dt><dd>{{index . 1}}</dd>{{end}}
+	{{if .Area}}<dt>Area</dt><dd>{{.Area}}</dd>{{end}}
+	{{if .Phones}}<dt>Phone</dt><dd>{{range $i, $p := .Phones}}{{if $i}}, {{end}}<a href="tel:{{$p}}">{{$p}}</a>{{end}}</dd>
+	{{else if .Phone}}<dt>Phone</dt><dd>{{.Phone}}</dd>{{end}}
+	{{if .Categories}}<dt>Categories</dt><dd>{{join .Categories ", "}}</dd>{{end}}
+	{{if .OpeningHours}}<dt>Opening hours</dt><dd>{{.OpeningHours}}</dd>{{end}}
+	{{if .Rating}}<dt>Rating</dt><dd>{{printf "%.2f" .Rating.Average}} from {{.Rating.Count}} reviews</dd>{{end}}
+	<dt>Visits</dt><dd>{{.Visits}}</dd>
+	<dt>Coordinates</dt><dd>{{.Lat}}, {{.Lon}}</dd>
+	{{if .Aliases}}<dt>Also known as</dt><dd>{{join .Aliases ", "}}</dd>{{end}}
+	<dt>Id</dt><dd>{{.ID}} (<a href="/api/v1/places/{{.ID}}">JSON</a>)</dd>
+</dl>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.MapWidth}}" height="{{.MapHeight}}" viewBox="0 0 {{.MapWidth}} {{.MapHeight}}">
+	<rect width="{{.MapWidth}}" height="{{.MapHeight}}" fill="#eef2f5" stroke="#8a9aa8"/>
+	{{range .Points}}
+	<circle cx="{{.X}}" cy="{{.Y}}" r="8" class="nearby"><title>{{.Title}}</title></circle>
+	<text x="{{.X}}" y="{{.Y}}" class="label">{{.Label}}</text>
+	{{end}}
+	{{with .Here}}<circle cx="{{.X}}" cy="{{.Y}}" r="6" class="place"><title>{{.Title}}</title></circle>{{end}}
+	<line x1="8" y1="{{.ScaleY}}" x2="{{.ScaleX}}" y2="{{.ScaleY}}" stroke="#333" stroke-width="2"/>
+	<text x="8" y="{{.ScaleTextY}}">{{.ScaleText}}</text>
+</svg>
+<h2>Nearby</h2>
+<ol>
+	{{range .Nearby}}
+	<li><a href="/places/{{.ID}}">{{.Name}}</a>, {{.Address}}, {{.DistanceM}} m</li>
+	{{end}}
+</ol>
+</body>
+</html>
+`
+
+// Page of a single place with a map of the places nearest to it. Ids of
+// places merged into another one redirect to the canonical place.
+func placePageHandler(store db.Store) http.HandlerFunc {
+	tmpl := template.Must(template.New("placeTemplate").Funcs(template.FuncMap{"join": strings.Join}).Parse(placeTemplate))
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, rest := placeIDFromPath(r.URL.Path)
+		if id == "" || rest != "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if notModifiedActivity(w, r, store, "place.html", id) {
+			return
+		}
+
+		place, err := store.GetPlace(id)
+		if err == types.ErrNotFound {
+			redirectMerged(w, r, store, id)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		lat, errLat := strconv.ParseFloat(place.Source.Location.Lat, 64)
+		lon, errLon := strconv.ParseFloat(place.Source.Location.Lon, 64)
+		if errLat != nil || errLon != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		nearby, err := nearbyPlaces(store, *place)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		src := place.Source
+		a := src.AddressParsed
+		var parts [][2]string
+		for _, part := range [][2]string{
+			{"City", a.City},
+			{"Settlement", a.Settlement},
+			{"Locality", a.Locality},
+			{"Street", strings.TrimSpace(a.StreetType + " " + a.Street)},
+			{"House", a.House},
+			{"Building", a.Building},
+			{"Structure", a.Structure},
+		} {
+			if part[1] != "" {
+				parts = append(parts, part)
+			}
+		}
+
+		data := struct {
+			ID           string
+			Name         string
+			Address      string
+			AddressParts [][2]string
+			Area         string
+			Phone        string
+			Phones       []string
+			Categories   []string
+			OpeningHours string
+			Rating       *types.Rating
+			Visits       int
+			Lat, Lon     float64
+			Aliases      []string
+			Nearby       []NearbyHTML
+			placeMapHTML
+		}{
+			ID:           place.ID,
+			Name:         src.Name,
+			Address:      src.Address,
+			AddressParts: parts,
+			Area:         src.Area,
+			Phone:        src.Phone,
+			Phones:       src.Phones,
+			Categories:   src.Category,
+			OpeningHours: src.OpeningHours,
+			Rating:       src.Rating,
+			Visits:       src.Visits,
+			Lat:          lat,
+			Lon:          lon,
+			Aliases:      src.Aliases,
+			placeMapHTML: newPlaceMap(src.Name, types.Location{Lat: lat, Lon: lon}, nearby),
+		}
+		for i, n := range nearby {
+			data.Nearby = append(data.Nearby, NearbyHTML{
+				ID:        n.Place.PlaceID,
+				Label:     strconv.Itoa(i + 1),
+				Name:      n.Place.Name,
+				Address:   n.Place.Address,
+				DistanceM: n.DistanceM,
+			})
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// Small static map of a place and its nearby places
+type placeMapHTML struct {
+	MapWidth, MapHeight float64
+	Here                MapPointHTML
+	Points              []MapPointHTML
+	ScaleX, ScaleY      float64
+	ScaleTextY          float64
+	ScaleText           string
+}
+
+// Fit a place and the places near it into the map, in Web Mercator
+func newPlaceMap(name string, here types.Location, nearby []nearbyPlace) placeMapHTML {
+	m := placeMapHTML{MapWidth: placeMapWidth, MapHeight: placeMapHeight}
+
+	// Span of the points in world units, from 0 to 1
+	hx, hy := tiles.Project(here.Lat, here.Lon)
+	minX, maxX, minY, maxY := hx, hx, hy, hy
+	for _, n := range nearby {
+		x, y := tiles.Project(n.Place.Location.Lat, n.Place.Location.Lon)
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	metersPerUnit := earthCircumference * math.Cos(here.Lat*math.Pi/180)
+	minSpan := placeMapMinSpan / metersPerUnit
+	spanX, spanY := math.Max(maxX-minX, minSpan), math.Max(maxY-minY, minSpan)
+	scale := math.Min((placeMapWidth-2*placeMapMargin)/spanX, (placeMapHeight-2*placeMapMargin)/spanY)
+
+	// Center the points
+	cx, cy := (minX+maxX)/2, (minY+maxY)/2
+	pixel := func(lat, lon float64) (float64, float64) {
+		x, y := tiles.Project(lat, lon)
+		return round2(placeMapWidth/2 + (x-cx)*scale), round2(placeMapHeight/2 + (y-cy)*scale)
+	}
+
+	m.Here.X, m.Here.Y = pixel(here.Lat, here.Lon)
+	m.Here.Title = name
+	for i, n := range nearby {
+		p := MapPointHTML{Label: strconv.Itoa(i + 1), Title: fmt.Sprintf("%s, %.0f m", n.Place.Name, n.DistanceM)}
+		p.X, p.Y = pixel(n.Place.Location.Lat, n.Place.Location.Lon)
+		m.Points = append(m.Points, p)
+	}
+
+	// Longest round length that fits the scale bar
+	pixelsPerMeter := scale / metersPerUnit
+	length := scaleBarLengths[0]
+	for _, l := range scaleBarLengths {
+		if l*pixelsPerMeter <= scaleBarMax {
+			length = l
+		}
+	}
+	m.ScaleX = round2(8 + length*pixelsPerMeter)
+	m.ScaleY = placeMapHeight - 8
+	m.ScaleTextY = placeMapHeight - 12
+	if length >= 1000 {
+		m.ScaleText = fmt.Sprintf("%g km", length/1000)
+	} else {
+		m.ScaleText = fmt.Sprintf("%g m", length)
+	}
+	return m
+}
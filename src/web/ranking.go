@@ -0,0 +1,92 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"day03es/db"
+	"day03es/types"
+)
+
+// Ranking preset used when the request has no mode
+const defaultMode = types.ModeBalanced
+
+// Extract the ranking of recommendations from request URL: a preset
+// given by mode, weights overriding it, preferred categories and explain
+func getRecOptionsFromRequest(r *http.Request) (types.RecOptions, error) {
+	query := r.URL.Query()
+
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = defaultMode
+	}
+	profile, ok := db.RankProfiles[mode]
+	if !ok {
+		return types.RecOptions{}, fmt.Errorf("Invalid 'mode' parameter, expected nearest, balanced or best")
+	}
+
+	// Weights of the preset can be changed one by one
+	for _, w := range []struct {
+		param string
+		value *float64
+	}{
+		{"scale", &profile.Scale},
+		{"w_distance", &profile.Distance},
+		{"w_rating", &profile.Rating},
+		{"w_reviews", &profile.Reviews},
+		{"w_category", &profile.Category},
+	} {
+		if v := query.Get(w.param); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				return types.RecOptions{}, fmt.Errorf("Invalid '%s' parameter", w.param)
+			}
+			*w.value = f
+		}
+	}
+	if profile.Scale == 0 {
+		return types.RecOptions{}, fmt.Errorf("Invalid 'scale' parameter")
+	}
+
+	diversity, err := getDiversityFromRequest(r)
+	if err != nil {
+		return types.RecOptions{}, err
+	}
+
+	opts := types.RecOptions{
+		Profile:   profile,
+		Explain:   query.Get("explain") == "true",
+		Diversity: diversity,
+	}
+	for _, c := range strings.Split(query.Get("prefer"), ",") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			opts.Prefer = append(opts.Prefer, c)
+		}
+	}
+	return opts, nil
+}
+
+// Default trade-off between ranking and variety
+const defaultLambda = 0.7
+
+// Extract the diversity of recommendations from request URL. Chains are
+// de-duplicated unless diversify=false, spread=categories also varies the
+// categories.
+func getDiversityFromRequest(r *http.Request) (types.Diversity, error) {
+	query := r.URL.Query()
+	d := types.Diversity{
+		Chains:     query.Get("diversify") != "false",
+		Categories: query.Get("spread") == "categories",
+		Lambda:     defaultLambda,
+	}
+	if v := query.Get("lambda"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return d, fmt.Errorf("Invalid 'lambda' parameter, expected a number from 0 to 1")
+		}
+		d.Lambda = f
+	}
+	return d, nil
+}
@@ -0,0 +1,222 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"day03es/db"
+	"day03es/types"
+)
+
+// Longest review text in characters
+const maxReviewLength = 2000
+
+// Reviews of a place: anyone can read the published ones, authenticated
+// users write, edit or delete their own
+func reviewsHandler(store db.Store) http.HandlerFunc {
+	list := cacheControl(cachePlaces, listReviewsHandler(store))
+	write := cacheControl(cacheNone, validateToken(writeReviewHandler(store)))
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			list(w, r)
+		case http.MethodPost, http.MethodPut, http.MethodDelete:
+			write(w, r)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST, PUT, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Published reviews of a place, newest first
+func listReviewsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := placeIDFromPath(r.URL.Path)
+		page, err := getPageFromRequest(r)
+		if err != nil || page < 1 {
+			http.Error(w, fmt.Sprintf("Invalid page value: '%d'", page), http.StatusBadRequest)
+			return
+		}
+
+		if notModifiedActivity(w, r, store, "reviews", id, strconv.Itoa(page)) {
+			return
+		}
+
+		reviews, total, err := store.ListReviews(id, types.ReviewPublished, limit, (page-1)*limit)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":    "Reviews",
+			"place":   id,
+			"total":   total,
+			"reviews": reviews,
+		})
+	}
+}
+
+// Create, replace or delete the review of the authenticated user
+func writeReviewHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := placeIDFromPath(r.URL.Path)
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			err := store.DeleteReview(db.ReviewID(id, user.key()))
+			if err == types.ErrReviewNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		var request struct {
+			Rating int    `json:"rating"`
+			Text   string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		request.Text = strings.TrimSpace(request.Text)
+		if request.Rating < 1 || request.Rating > 5 {
+			http.Error(w, "'rating' must be from 1 to 5", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(request.Text) > maxReviewLength {
+			http.Error(w, fmt.Sprintf("'text' is longer than %d characters", maxReviewLength), http.StatusBadRequest)
+			return
+		}
+
+		review, err := store.PutReview(types.Review{
+			PlaceID: id,
+			User:    user.key(),
+			Rating:  request.Rating,
+			Text:    request.Text,
+		})
+		if err == types.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":   "Review",
+			"review": review,
+		})
+	}
+}
+
+// Reviews of every place for moderation, filtered by ?status= and ?place=
+func adminReviewsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, err := getPageFromRequest(r)
+		if err != nil || page < 1 {
+			http.Error(w, fmt.Sprintf("Invalid page value: '%d'", page), http.StatusBadRequest)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		if status != "" && status != types.ReviewPublished && status != types.ReviewHidden {
+			http.Error(w, "Invalid 'status' parameter", http.StatusBadRequest)
+			return
+		}
+
+		reviews, total, err := store.ListReviews(r.URL.Query().Get("place"), status, limit, (page-1)*limit)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":    "Reviews",
+			"total":   total,
+			"reviews": reviews,
+		})
+	}
+}
+
+// Publish or hide a review with PATCH {"status": "hidden"}, or DELETE it
+func moderateReviewHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path[strings.Index(r.URL.Path, "/admin/reviews/"):], "/admin/reviews/")
+		if id == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var (
+			review *types.Review
+			err    error
+		)
+		switch r.Method {
+		case http.MethodDelete:
+			err = store.DeleteReview(id)
+		case http.MethodPatch:
+			var request struct {
+				Status string `json:"status"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if request.Status != types.ReviewPublished && request.Status != types.ReviewHidden {
+				http.Error(w, "'status' must be published or hidden", http.StatusBadRequest)
+				return
+			}
+			review, err = store.SetReviewStatus(id, request.Status)
+		default:
+			w.Header().Set("Allow", "PATCH, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if errors.Is(err, types.ErrReviewNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if review == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":   "Review",
+			"review": review,
+		})
+	}
+}
+
+// Write an indented JSON response, as application/json unless another
+// JSON type was set
+func writeJSON(w http.ResponseWriter, status int, response interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(response); err != nil {
+		log.Println("writeJSON:", err)
+	}
+}
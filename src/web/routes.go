@@ -0,0 +1,40 @@
+package web
+
+import (
+	"net/http"
+	"time"
+)
+
+// Prefix of the current API version
+const apiV1 = "/api/v1"
+
+// Date after which the unversioned /api/... aliases will be removed
+var sunsetDate = time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)
+
+// Register an API route under /api/v1/ and keep the old unversioned
+// path as a deprecated alias
+func handleAPI(mux *http.ServeMux, path string, h http.HandlerFunc) {
+	mux.HandleFunc(apiV1+path, h)
+	mux.HandleFunc("/api"+path, deprecated(apiV1+path, h))
+}
+
+// Middleware that marks a response as coming from a deprecated route
+func deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", sunsetDate.Format(http.TimeFormat))
+		w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		next(w, r)
+	}
+}
+
+// Only serve the handler on the exact path, everything else is 404
+func exactPath(path string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
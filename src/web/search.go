@@ -0,0 +1,88 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"day03es/db"
+	"day03es/types"
+)
+
+// Full text search over place names and addresses
+func searchHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" {
+			http.Error(w, "Missing 'q' parameter", http.StatusBadRequest)
+			return
+		}
+
+		page, err := getPageFromRequest(r)
+		if err != nil || page < 1 {
+			http.Error(w, fmt.Sprintf("Invalid page value: '%d'", page), http.StatusBadRequest)
+			return
+		}
+
+		sort := r.URL.Query().Get("sort")
+		if sort == "" {
+			sort = types.SortRelevance
+		} else if sort != types.SortRelevance && sort != types.SortPopular {
+			http.Error(w, "Invalid 'sort' parameter, expected relevance or popular", http.StatusBadRequest)
+			return
+		}
+
+		openAt, err := getOpenAtFromRequest(r)
+		if err != nil {
+			http.Error(w, "Invalid 'open_at' parameter", http.StatusBadRequest)
+			return
+		}
+
+		// "Open now" depends on the time of the request
+		if !openAt.IsZero() {
+			limitMaxAge(w, cacheOpenMaxAge)
+		}
+		now := ""
+		if r.URL.Query().Get("open_now") == "true" {
+			now = openAt.Truncate(time.Minute).Format(time.RFC3339)
+		}
+		if notModifiedActivity(w, r, store, "search", r.URL.Query().Encode(), now) {
+			return
+		}
+
+		filter := getFilterFromRequest(r)
+		filter.OpenAt = openAt
+		places, total, err := store.Search(q, filter, sort, limit, (page-1)*limit)
+		if err == types.ErrInvalidPage {
+			http.Error(w, fmt.Sprintf("Invalid page value: '%d'", page), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		facets, err := store.Facets(q, filter)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		response := map[string]interface{}{
+			"name":   "Search",
+			"query":  q,
+			"sort":   sort,
+			"total":  total,
+			"facets": facets,
+			"places": placesToJSON(places),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(response); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"day03es/db"
+	"day03es/types"
+)
+
+// Default number of suggestions
+const suggestLimit = 8
+
+// Cache policy for suggestions, they only change with the index
+const cacheSuggest = "public, max-age=3600"
+
+func suggestHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" {
+			http.Error(w, "Missing 'q' parameter", http.StatusBadRequest)
+			return
+		}
+
+		limit := suggestLimit
+		if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+			var err error
+			limit, err = strconv.Atoi(limitParam)
+			if err != nil || limit <= 0 {
+				http.Error(w, "Invalid 'limit' parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		loc, err := optionalLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Share cached suggestions between clients in the same grid cell
+		locKey := ""
+		if loc != nil {
+			loc.Lat, loc.Lon = snapToGrid(loc.Lat), snapToGrid(loc.Lon)
+			locKey = fmt.Sprint(loc.Lat, loc.Lon)
+		}
+		if notModified(w, r, store, "suggest", strings.ToLower(q), strconv.Itoa(limit), locKey) {
+			return
+		}
+
+		suggestions, err := store.Suggest(q, limit, loc)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		response := map[string]interface{}{
+			"name":        "Suggestions",
+			"suggestions": suggestions,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(response); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// Parse optional lat/lon parameters, both must be given together
+func optionalLocation(r *http.Request) (*types.Location, error) {
+	latParam := r.URL.Query().Get("lat")
+	lonParam := r.URL.Query().Get("lon")
+	if latParam == "" && lonParam == "" {
+		return nil, nil
+	}
+
+	lat, err := strconv.ParseFloat(latParam, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return nil, fmt.Errorf("Invalid 'lat' parameter")
+	}
+	lon, err := strconv.ParseFloat(lonParam, 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return nil, fmt.Errorf("Invalid 'lon' parameter")
+	}
+	return &types.Location{Lat: lat, Lon: lon}, nil
+}
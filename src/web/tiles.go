@@ -0,0 +1,94 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"day03es/db"
+	"day03es/tiles"
+)
+
+// Tiles only change with the index
+const cacheTiles = "public, max-age=3600"
+
+// Most tiles kept in memory
+const maxCachedTiles = 4096
+
+// Tiles built for one index generation
+type tileCache struct {
+	sync.Mutex
+	generation string
+	tiles      map[tiles.Tile][]byte
+}
+
+// Tile built for a generation, if it is cached
+func (c *tileCache) get(gen string, t tiles.Tile) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if c.generation != gen {
+		return nil, false
+	}
+	b, ok := c.tiles[t]
+	return b, ok
+}
+
+// Keep a tile, forgetting the tiles of older generations
+func (c *tileCache) put(gen string, t tiles.Tile, b []byte) {
+	c.Lock()
+	defer c.Unlock()
+	if c.generation != gen || len(c.tiles) >= maxCachedTiles {
+		c.generation = gen
+		c.tiles = make(map[tiles.Tile][]byte)
+	}
+	c.tiles[t] = b
+}
+
+// Read "/tiles/12/2476/1280.mvt"
+func tileFromPath(path string) (tiles.Tile, bool) {
+	rest := strings.TrimPrefix(path, "/tiles/")
+	if rest == path || !strings.HasSuffix(rest, ".mvt") {
+		return tiles.Tile{}, false
+	}
+	t, err := tiles.ParseTile(strings.TrimSuffix(rest, ".mvt"))
+	return t, err == nil
+}
+
+// Mapbox Vector Tiles of the places at /tiles/{z}/{x}/{y}.mvt
+func tilesHandler(store db.Store) http.HandlerFunc {
+	cache := &tileCache{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := tileFromPath(r.URL.Path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if notModified(w, r, store, "tile", t.String()) {
+			return
+		}
+
+		gen, err := store.Generation()
+		if err != nil {
+			// Serve the tile without caching it
+			log.Println("tilesHandler:", err)
+		}
+		tile, ok := cache.get(gen, t)
+		if !ok {
+			tile, err = store.VectorTile(t)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if gen != "" {
+				cache.put(gen, t, tile)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+		w.Header().Set("Content-Length", strconv.Itoa(len(tile)))
+		w.Write(tile)
+	}
+}